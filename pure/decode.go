@@ -1,43 +1,111 @@
 package pure
 
 import (
-	"bytes"
+	"encoding"
+	"errors"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
+	"math/big"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/Krognol/go-pure/pure/scanner"
 )
 
-type Parser struct {
-	buf                *bytes.Buffer
-	start, end, actual int
-	line, col          int
-	src                []byte
+// decodeOptions are the settings of a Decoder
+type decodeOptions struct {
+	// Decode sources with syntax errors too, and keep every error
+	all bool
+
+	disallowUnknownKeys bool
+	resolver            Resolver
+
+	// Maximum size of the source and of each included file, 0 for no limit
+	maxSize int64
+
+	// Limits on include nesting and on the number of included files,
+	// used instead of the defaults if includeLimits is set
+	includeLimits      bool
+	maxDepth, maxFiles int
+
+	// Directory includes are confined to, empty for none
+	includeRoot string
+
+	// Expand environment variables in every value, not just in fields
+	// with the env tag option. lookupEnv is os.LookupEnv if it's nil
+	expandEnv bool
+	lookupEnv func(string) (string, bool)
+
+	// Override keys with environment variables named after them, see
+	// decodeState.overlay
+	envOverlay bool
+	envPrefix  string
 }
 
-func newParser(src []byte) *Parser {
-	return &Parser{
-		buf:  bytes.NewBuffer(src),
-		line: 1,
-		src:  src,
-	}
+// decodeState stores the values of a Document in Go values.
+// Like encoding/json it carries on when a value doesn't fit its field,
+// and keeps every error it runs into
+type decodeState struct {
+	*decodeOptions
+	errs ErrorList
 }
 
-func (p *Parser) reportErr(msg string) {
-	var pointer string
-	point := fmt.Sprintf("Error [%d : %d] : %s", p.line, p.col, string(p.src[p.start:p.end]))
+func (d *decodeState) error(n *Node, kind ErrorKind, key, msg string, err error) {
+	d.errs = append(d.errs, &DecodeError{
+		Pos:   n.Pos,
+		Kind:  kind,
+		Key:   key,
+		Value: n.Raw,
+		Msg:   msg,
+		Err:   err,
+	})
+}
 
-	for i := 0; i < len(point)-1; i++ {
-		pointer += "-"
+// typeErr reports that the node n couldn't be stored in a value of type typ
+func (d *decodeState) typeErr(n *Node, key string, typ reflect.Type, err error) {
+	what := strconv.Quote(n.Raw)
+	if n.Kind != PropertyNode {
+		what = n.Kind.String()
 	}
-	pointer += "^"
+	d.error(n, ValueIncorrectType, key, fmt.Sprintf("cannot decode %s as %s", what, typ), err)
+}
+
+// Unmarshaler is implemented by types that decode their own Pure value.
+// raw is the value as written in the source, quotes included. For
+// groups it's the source of their members and for arrays the whole
+// array, brackets included
+type Unmarshaler interface {
+	UnmarshalPure(raw []byte) error
+}
 
-	fmt.Printf("%s\n%s\n%s\n", point, pointer, msg)
+var (
+	unmarshalerType     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// implementsUnmarshaler reports whether t or a pointer to it is an
+// Unmarshaler or an encoding.TextUnmarshaler
+func implementsUnmarshaler(t reflect.Type) bool {
+	for _, typ := range []reflect.Type{t, reflect.PtrTo(t)} {
+		if typ.Implements(unmarshalerType) || typ.Implements(textUnmarshalerType) {
+			return true
+		}
+	}
+	return false
 }
 
+// indirect walks down v through pointers and interfaces, allocating
+// nil pointers on the way. It stops at the first Unmarshaler or
+// encoding.TextUnmarshaler
+//
 // Shamelessly stolen from the Golang JSON decode source. Forgive
-func indirect(v reflect.Value) reflect.Value {
+func indirect(v reflect.Value) (Unmarshaler, encoding.TextUnmarshaler, reflect.Value) {
+	// Start with the address of a named value, so that methods with
+	// a pointer receiver are found too
 	if v.Kind() != reflect.Ptr && v.Type().Name() != "" && v.CanAddr() {
 		v = v.Addr()
 	}
@@ -55,602 +123,666 @@ func indirect(v reflect.Value) reflect.Value {
 			break
 		}
 
-		if v.Elem().Kind() != reflect.Ptr && v.CanSet() {
-			break
-		}
-
 		if v.IsNil() {
+			if !v.CanSet() {
+				break
+			}
 			v.Set(reflect.New(v.Type().Elem()))
 		}
 
-		if v.Type().NumMethod() > 0 {
-			// TODO
+		if v.Type().NumMethod() > 0 && v.CanInterface() {
+			if u, ok := v.Interface().(Unmarshaler); ok {
+				return u, nil, reflect.Value{}
+			}
+			if u, ok := v.Interface().(encoding.TextUnmarshaler); ok {
+				return nil, u, reflect.Value{}
+			}
 		}
 
 		v = v.Elem()
 	}
-	return v
-}
-
-func isDigit(b byte) bool {
-	return b >= '0' && b <= '9'
-}
-
-func isAlpha(b byte) bool {
-	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
-}
-
-func isAlNum(b byte) bool {
-	// Should allow special characters too for identifiers
-	return isAlpha(b) || isDigit(b) || b == '_'
-}
-
-func isWhiteSpace(b byte) bool {
-	return b == ' ' || b == '\n' || b == '\t'
-}
-
-func (p *Parser) consumeComment() {
-	for {
-		if p.getNext() == 10 {
-			break
-		}
-	}
+	return nil, nil, v
 }
 
-func getField(ident string, v reflect.Value) (reflect.Value, bool) {
-	var isUnquoted bool
-	lenIdent := len(ident)
-	var iv reflect.Value
-	var tag string
-	if v.Kind() == reflect.Ptr {
-		iv = indirect(v.Elem())
-		for i := 0; i < iv.NumField(); i++ {
-			tag = iv.Type().Field(i).Tag.Get("pure")
-
-			if len(tag) >= lenIdent && tag[lenIdent:] == ",unquoted" {
-				isUnquoted = true
-
-				tag = tag[:lenIdent]
-
-			}
-
-			if tag == "" || tag == "-" || tag != ident {
-				isUnquoted = false
-				continue
-			}
-
-			return iv.Field(i), isUnquoted
+// getField returns the exported field of the struct v tagged with
+// ident, and its tag options. The field is invalid if there's none.
+// Unexported fields are skipped like untagged ones, they can't be set
+func getField(ident string, v reflect.Value) (reflect.Value, tagOptions) {
+	tv := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		tag, opts := parseTag(tv.Field(i).Tag.Get("pure"))
+		if tag == "" || tag == "-" || tv.Field(i).PkgPath != "" {
+			continue
 		}
-	}
 
-	if v.Kind() == reflect.Struct {
-		iv = indirect(v)
-		tv := reflect.TypeOf(v.Interface())
-
-		for i := 0; i < iv.NumField(); i++ {
-			tag = tv.Field(i).Tag.Get("pure")
-
-			if len(tag) >= lenIdent && tag[lenIdent:] == ",unquoted" {
-				isUnquoted = true
-				tag = tag[:lenIdent]
-			}
-
-			if tag == "" || tag == "-" || tag != ident {
-				isUnquoted = false
-				continue
-			}
-
-			return iv.Field(i), isUnquoted
+		if tag == ident {
+			return v.Field(i), opts
 		}
 	}
-	return indirect(v), isUnquoted
-}
-
-func (p *Parser) peek() byte {
-	b, _ := p.buf.ReadByte()
-	p.buf.UnreadByte()
-	return b
+	return reflect.Value{}, ""
 }
 
-// Peek the first n bytes in the buffer
-func (p *Parser) peekn(n int) []byte {
-
-	// Keep a backup of the current buffer
-	backup := p.buf
-	bs := [64]byte{}
-
-	// Check the first n bytes
-	for i := 0; i < n; i++ {
-		b, _ := p.buf.ReadByte()
-		bs[i] = b
+// joinKey appends key to the dotted key path prefix
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
 	}
-
-	// Rewind
-	p.buf = backup
-	return bs[:n]
+	return prefix + "." + key
 }
 
-func (p *Parser) getValue() []byte {
-	var buf = bytes.NewBuffer(nil)
-	var b byte
-	// Skip the leading whitespaces
-	for {
-		if isWhiteSpace(p.getNext()) {
-			continue
-		}
-		p.buf.UnreadByte()
-		p.actual--
-		break
+// unescape resolves character escapes and joins lines ending in '\'
+func unescape(value string) string {
+	if strings.IndexByte(value, '\\') < 0 {
+		return value
 	}
 
-	// Grab any byte until we hit a new line
-	for {
-		b = p.getNext()
-
-		if b == '\\' {
-			peek := p.peek()
-			if peek == ' ' || peek == '\r' || peek == '\n' {
-				p.getNext()
-				p.getNext()
-				for {
-					if !isWhiteSpace(p.getNext()) {
-						p.buf.UnreadByte()
-						break
-					}
-				}
-				continue
-			}
+	var buf strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 >= len(value) {
+			buf.WriteByte(value[i])
+			continue
 		}
 
-		if b == 0 || b == 10 {
-			break
+		// A '\' at the end of a line joins it with the next one,
+		// dropping the indentation
+		j := i + 1
+		for j < len(value) && (value[j] == ' ' || value[j] == '\t' || value[j] == '\r') {
+			j++
 		}
-
-		buf.WriteByte(b)
-	}
-	return buf.Bytes()
-}
-
-func (p *Parser) verifyValue(value string) string {
-	var buf = bytes.NewBuffer(nil)
-
-	for i := 0; i < len(value); i++ {
-
-		// Character escape
-		if value[i] == '\\' {
-			i++
-			if i > len(value) {
-				i--
-				break
+		if j < len(value) && value[j] == '\n' {
+			j++
+			for j < len(value) && (value[j] == ' ' || value[j] == '\t') {
+				j++
 			}
+			i = j - 1
+			continue
 		}
 
+		// Character escape
+		i++
 		buf.WriteByte(value[i])
 	}
 	return buf.String()
 }
 
-func (p *Parser) fieldSetValue(field reflect.Value, val string, unq bool) error {
+// unquote returns the value of a string or unquoted literal, with
+// quotes removed and escapes resolved
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	return unescape(value)
+}
+
+// rangeError is returned by fieldSetValue for numbers that don't fit
+// their field
+type rangeError struct {
+	kind ErrorKind
+	msg  string
+}
 
-	val = strings.Replace(val, "\r", "", -1)
+func (e *rangeError) Error() string {
+	return e.msg
+}
+
+func fieldSetValue(field reflect.Value, val string, unq bool) error {
 	switch field.Kind() {
-	case reflect.Int:
-		i, err := strconv.ParseInt(val, 10, 64)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := parseInt(val, field.Type())
 		if err != nil {
 			return err
 		}
 		field.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := parseUint(val, field.Type())
+		if err != nil {
+			return err
+		}
+		field.SetUint(u)
 	case reflect.Float32, reflect.Float64:
-		f, err := strconv.ParseFloat(val, 64)
+		f, err := parseFloat(val, field.Type())
 		if err != nil {
 			return err
 		}
 		field.SetFloat(f)
+	case reflect.Complex64, reflect.Complex128:
+		c, err := strconv.ParseComplex(unquote(val), field.Type().Bits())
+		if err != nil {
+			if isRangeErr(err) {
+				return tooLarge(val, field.Type())
+			}
+			return err
+		}
+		field.SetComplex(c)
 	case reflect.String:
-		if unq || val[0] != '"' {
-			field.SetString(p.verifyValue(val))
+		if unq {
+			field.SetString(unescape(val))
 			break
 		}
-		field.SetString(p.verifyValue(val[1 : len(val)-1]))
+		field.SetString(unquote(val))
 	case reflect.Bool:
 		b, err := strconv.ParseBool(strings.ToLower(val))
 		if err != nil {
 			return err
 		}
 		field.SetBool(b)
+	default:
+		return errNotProperty
 	}
 	return nil
 }
 
-func (p *Parser) parseMap(v reflect.Value) (reflect.Value, error) {
-	// We've already consumed the bracket
-	// so just start getting the keys and values
-	// straight away
-	var buf = bytes.NewBuffer(nil)
-
-	iv := indirect(v)
-	for b := p.getNext(); b != ']'; b = p.getNext() {
-		if isWhiteSpace(b) || b == '\r' {
-			continue
-		}
+// setBytes stores a byte size like 512MiB in the integer field as a
+// number of bytes
+func setBytes(field reflect.Value, val string) error {
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+	default:
+		return errNotProperty
+	}
 
-		if b == 0 {
-			p.end = p.actual - 1
-			return iv, fmt.Errorf("Invalid map property, missing ']'")
-		}
-
-		if !isAlNum(b) {
-
-			// TODO :: Add map value referencing
-			// TODO :: Add map groups
-			if b == '=' {
-
-				value := bytes.Replace(p.getValue(), []byte("\r"), nil, -1)
-				var mval reflect.Value
-				switch iv.Type().Elem().Kind() {
-				case reflect.Int:
-					i, err := strconv.Atoi(string(value))
-					if err != nil {
-						p.end = p.actual - 1
-						return iv, err
-					}
-					mval = reflect.ValueOf(i)
-				case reflect.Float64:
-					f, err := strconv.ParseFloat(string(value), 64)
-					if err != nil {
-						p.end = p.actual - 1
-						return iv, err
-					}
-					mval = reflect.ValueOf(f)
-				case reflect.Bool:
-					bol, err := strconv.ParseBool(string(value))
-					if err != nil {
-						p.end = p.actual - 1
-						return iv, err
-					}
-					mval = reflect.ValueOf(bol)
-				case reflect.String:
-					mval = reflect.ValueOf(string(value))
-				default:
-					p.end = p.actual - 1
-					return iv, fmt.Errorf("Invalid type %s", iv.Kind().String())
-				}
-				iv.SetMapIndex(reflect.ValueOf(buf.String()), mval)
-				buf.Reset()
-				continue
-			}
-		}
-		buf.WriteByte(b)
+	n, err := exactIn(unquote(val), byteUnit)
+	if err != nil {
+		return err
+	}
+	if !n.IsInt() {
+		return rounded(val, field.Type())
 	}
-	return iv, nil
+	err = fieldSetValue(field, n.Num().String(), false)
+	if _, ok := err.(*rangeError); ok {
+		return tooLarge(val, field.Type())
+	}
+	return err
 }
 
-func (p *Parser) getNext() byte {
-	b, _ := p.buf.ReadByte()
-	p.col++
-	p.actual++
+var byteUnit = Unit{factors: []unitFactor{{"B", 1}}}
 
-	if b == 10 {
-		p.line++
-		p.col = 0
+// setDuration stores a duration like 1m30s in the integer field as a
+// number of nanoseconds
+func setDuration(field reflect.Value, val string) error {
+	if field.Kind() != reflect.Int64 {
+		return errNotProperty
 	}
 
-	if b == 0 {
-		p.col--
-		p.actual--
+	d, err := time.ParseDuration(unquote(val))
+	if err != nil {
+		return err
 	}
-	return b
+	field.SetInt(int64(d))
+	return nil
 }
 
-func (p *Parser) parseArray(v reflect.Value) (reflect.Value, error) {
-	// Consume the '['
-	p.getNext()
-	var val []byte
-	value := indirect(v)
+// errNotProperty is returned by fieldSetValue for fields that can't
+// hold a property
+var errNotProperty = errors.New("not a property type")
 
-	if value.Kind() == reflect.Map {
-		return p.parseMap(v)
-	}
+func isRangeErr(err error) bool {
+	nerr, ok := err.(*strconv.NumError)
+	return ok && nerr.Err == strconv.ErrRange
+}
 
-	for b := p.getNext(); b != ']'; b = p.getNext() {
-		val = bytes.Replace(p.getValue(), []byte("\r"), nil, -1)
-		if len(val) <= 0 {
-			continue
-		}
-		var app reflect.Value
-		switch value.Type().Elem().Kind() {
-		case reflect.Int:
-			i, err := strconv.Atoi(string(val))
-			if err != nil {
-				return value, err
-			}
-			app = reflect.ValueOf(i)
-		case reflect.Float64:
-			f, err := strconv.ParseFloat(string(val), 64)
-			if err != nil {
-				return value, err
-			}
-			app = reflect.ValueOf(f)
-		case reflect.Bool:
-			bol, err := strconv.ParseBool(string(val))
-			if err != nil {
-				return value, err
-			}
-			app = reflect.ValueOf(bol)
-		case reflect.String:
-			str := string(val)
-			app = reflect.ValueOf(str[1 : len(str)-1])
-		default:
-			return value, fmt.Errorf("Invalid type %s", value.Kind().String())
-		}
-		value = reflect.Append(value, app)
-	}
-	return value, nil
+func tooLarge(val string, typ reflect.Type) error {
+	return &rangeError{NumberTooLarge, fmt.Sprintf("%s overflows %s", val, typ)}
 }
 
-func (p *Parser) parseIdent(v reflect.Value) error {
-	var b byte
-	var buf = bytes.NewBuffer(nil)
-	backup := v
-	p.start = p.actual
-	// While the current byte is a letter or number
-	// assume it's part of the identifier
-	for {
-		b = p.getNext()
+func rounded(val string, typ reflect.Type) error {
+	return &rangeError{RoundedDecimal, fmt.Sprintf("%s would be rounded to fit %s", val, typ)}
+}
 
-		if b == 0 {
-			p.end = p.actual - 1
-			p.reportErr("Identifier missing value")
-			return nil
-		}
+// integral returns the value of a number written with a fraction or
+// an exponent, if it's a whole number
+func integral(val string, typ reflect.Type) (*big.Int, error) {
+	// big.Rat also reads fractions and base prefixes, Pure doesn't
+	r, ok := new(big.Rat).SetString(val)
+	if !ok || strings.ContainsAny(val, "/_xXbBoO") {
+		return nil, &strconv.NumError{Func: "ParseInt", Num: val, Err: strconv.ErrSyntax}
+	}
+	if !r.IsInt() {
+		return nil, rounded(val, typ)
+	}
+	return r.Num(), nil
+}
 
-		if isWhiteSpace(b) {
-			continue
+// parseInt parses val as an integer that fits in typ
+func parseInt(val string, typ reflect.Type) (int64, error) {
+	i, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		if isRangeErr(err) {
+			return 0, tooLarge(val, typ)
 		}
 
-		if !isAlNum(b) {
-			p.buf.UnreadByte()
-			p.actual--
-			break
+		n, ierr := integral(val, typ)
+		if ierr != nil {
+			return 0, ierr
 		}
-
-		buf.WriteByte(b)
+		if !n.IsInt64() {
+			return 0, tooLarge(val, typ)
+		}
+		i = n.Int64()
 	}
 
-	// Skip trailing whitespaces until we hit a '='
-	for {
-		b = p.getNext()
+	if reflect.Zero(typ).OverflowInt(i) {
+		return 0, tooLarge(val, typ)
+	}
+	return i, nil
+}
 
-		if b == 0 {
-			p.end = p.actual - 1
-			p.reportErr("Identifier missing value")
-			break
+// parseUint parses val as an unsigned integer that fits in typ
+func parseUint(val string, typ reflect.Type) (uint64, error) {
+	u, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		if isRangeErr(err) {
+			return 0, tooLarge(val, typ)
 		}
 
-		if b == 10 {
-			for {
-				if p.peek() == '\t' || p.peek() == ' ' {
-					field, _ := getField(buf.String(), v)
-					p.parseIdent(field)
-					continue
-				}
-				break
-			}
-			break
+		n, ierr := integral(val, typ)
+		if ierr != nil {
+			return 0, ierr
 		}
-
-		if isWhiteSpace(b) {
-			continue
+		if !n.IsUint64() {
+			return 0, tooLarge(val, typ)
 		}
+		u = n.Uint64()
+	}
 
-		// We're assigning a group variable
-		if b == '.' {
-			group, _ := getField(buf.String(), v)
-			buf.Reset()
-			for {
-				b = p.getNext()
-				if b == 0 {
-					p.end = p.actual - 1
-					p.reportErr("Missing group variable identifier")
-					break
-				}
-
-				if !isAlNum(b) {
-					p.buf.UnreadByte()
-					p.actual--
-					break
-				}
-
-				buf.WriteByte(b)
-			}
+	if reflect.Zero(typ).OverflowUint(u) {
+		return 0, tooLarge(val, typ)
+	}
+	return u, nil
+}
 
-			//ident = field
-			v = group
+// parseFloat parses val as a floating point number the size of typ.
+// It fails if the digits written don't survive the conversion
+func parseFloat(val string, typ reflect.Type) (float64, error) {
+	f, err := strconv.ParseFloat(val, typ.Bits())
+	if err != nil {
+		if isRangeErr(err) {
+			return 0, tooLarge(val, typ)
 		}
+		return 0, err
+	}
 
-		if b == '=' {
-			// Get the field from the struct that has a tag that matches
-			// ident
-			field, unquoted := getField(buf.String(), v)
-			peek := p.peek()
-			if isWhiteSpace(peek) {
-				p.getNext()
-				peek = p.peek()
-			}
+	written, ok := new(big.Rat).SetString(val)
+	shortest, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, typ.Bits()))
+	if ok && written.Cmp(shortest) != 0 {
+		return 0, rounded(val, typ)
+	}
+	return f, nil
+}
 
-			if peek == '[' {
-				values, err := p.parseArray(field)
-				if err != nil {
-					p.end = p.actual - 1
-					p.reportErr(err.Error())
-					return err
-				}
+// isScalarKind reports whether arrays and maps can hold values of kind k
+func isScalarKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128,
+		reflect.Bool, reflect.String:
+		return true
+	}
+	return false
+}
 
-				field.Set(values)
-				return nil
-			}
+// value stores the node n in field. Environment variables in properties
+// are expanded first if the options ask for it
+func (d *decodeState) value(n *Node, field reflect.Value, key string, opts tagOptions) {
+	// References that couldn't be resolved are left as properties
+	// without a value. The parser reported them already
+	if n.Ref != "" && n.Kind == PropertyNode && n.Raw == "" {
+		return
+	}
 
-			// Check for reference values
-			if peek == '>' {
-				// Consume the '>'
-				p.getNext()
-				return p.parseReference(field, backup)
+	if n.Kind == PropertyNode && (d.expandEnv || opts.Contains("env")) {
+		raw, err := expandEnv(n.Raw, d.lookupEnv)
+		if err != nil {
+			d.error(n, ExpandFailed, key, "cannot expand environment variables", err)
+			return
+		}
+		expanded := *n
+		expanded.Raw = raw
+		n = &expanded
+	}
 
-			}
+	u, tu, v := indirect(field)
+	if u != nil {
+		if err := u.UnmarshalPure(nodeSource(n)); err != nil {
+			d.typeErr(n, key, field.Type(), err)
+		}
+		return
+	}
 
-			// Get the value of the field and set it
-			// Throws an error if the value is invalid
-			val := p.getValue()
-			err := p.fieldSetValue(field, string(val), unquoted)
+	// Text is handed over without quotes
+	if tu != nil {
+		if n.Kind != PropertyNode {
+			d.typeErr(n, key, field.Type(), nil)
+			return
+		}
+		if t, ok := tu.(*time.Time); ok {
+			parsed, err := parseTime(unquote(n.Raw), opts.layout())
 			if err != nil {
-				p.end = p.actual - 1
-				p.reportErr("Couldn't set field value " + string(val))
-				return err
+				d.typeErr(n, key, field.Type(), err)
+				return
 			}
+			*t = parsed
+			return
+		}
+		if err := tu.UnmarshalText([]byte(unquote(n.Raw))); err != nil {
+			d.typeErr(n, key, field.Type(), err)
+		}
+		return
+	}
+
+	// Empty interfaces get whatever fits the node best
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		if elem, ok := d.element(n, interfaceType(n), key, opts); ok {
+			v.Set(elem)
+		}
+		return
+	}
+
+	switch n.Kind {
+	case GroupNode:
+		if v.Kind() == reflect.Map {
+			d.keyValuePair(n, v, key, opts)
+			break
+		}
+		d.group(n, v, key)
+	case PropertyNode:
+		if opts.Contains("path") || v.Type() == pathType {
+			d.path(n, v, key, opts)
 			break
 		}
 
+		var err error
+		switch {
+		case opts.Contains("bytes"):
+			err = setBytes(v, n.Raw)
+		case opts.Contains("duration"), v.Type() == durationType:
+			err = setDuration(v, n.Raw)
+		default:
+			err = fieldSetValue(v, n.Raw, opts.Contains("unquoted"))
+		}
+		if rerr, ok := err.(*rangeError); ok {
+			d.error(n, rerr.kind, key, rerr.msg, nil)
+		} else if err == errNotProperty {
+			d.typeErr(n, key, v.Type(), nil)
+		} else if err != nil {
+			d.typeErr(n, key, v.Type(), err)
+		}
+	case ArrayNode, MapNode:
+		switch {
+		case v.Kind() == reflect.Map:
+			d.keyValuePair(n, v, key, opts)
+		case v.Kind() == reflect.Struct && n.Kind == MapNode:
+			// Keyed entries are the members of a group
+			d.group(n, v, key)
+		default:
+			d.array(n, v, key, opts)
+		}
 	}
-	return nil
 }
 
-func (p *Parser) parseReference(field reflect.Value, v reflect.Value) error {
-	getFrom := p.getValue()
-	getFrom = bytes.Replace(getFrom, []byte("\r"), nil, -1)
+// group stores the members of the group n in the struct v
+func (d *decodeState) group(n *Node, v reflect.Value, key string) {
+	if v.Kind() == reflect.Ptr {
+		d.error(n, ValueIncorrectType, key, "cannot decode group into nil "+v.Type().String(), nil)
+		return
+	}
+
+	if v.Kind() != reflect.Struct {
+		d.typeErr(n, key, v.Type(), nil)
+		return
+	}
 
-	if index := bytes.IndexByte(getFrom, '.'); index != -1 {
-		group, _ := getField(string(getFrom[:index]), v)
-		getFrom = getFrom[index+1:]
-		v = group
+	for _, child := range n.Children {
+		field, opts := getField(child.Key, v)
+		if !field.IsValid() {
+			if d.disallowUnknownKeys {
+				d.error(child, UnexpectedKey, joinKey(key, child.Key), "unknown key", nil)
+			}
+			continue
+		}
+		d.value(child, field, joinKey(key, child.Key), opts)
 	}
+}
 
-	fromField, _ := getField(string(getFrom), v)
-	var value string
+var (
+	mapInterfaceType   = reflect.TypeOf(map[string]interface{}(nil))
+	sliceInterfaceType = reflect.TypeOf([]interface{}(nil))
+)
 
-	switch fromField.Kind() {
-	case reflect.Int:
-		value = strconv.Itoa(int(fromField.Int()))
-	case reflect.Float64:
-		value = strconv.FormatFloat(fromField.Float(), 'f', 12, 64)
-	case reflect.Bool:
-		value = strconv.FormatBool(fromField.Bool())
-	default:
-		value = fromField.String()
+// interfaceType returns the type n is decoded as into an empty
+// interface. Groups and maps become map[string]interface{}, arrays
+// []interface{}, and properties int64, float64, bool or string
+func interfaceType(n *Node) reflect.Type {
+	switch n.Kind {
+	case GroupNode, MapNode:
+		return mapInterfaceType
+	case ArrayNode:
+		return sliceInterfaceType
 	}
 
-	err := p.fieldSetValue(field, value, false)
-	if err != nil {
-		p.end = p.actual - 1
-		p.reportErr("Couldn't set field value " + value)
-		return err
+	// The type of a value depends on what's before it, so it's
+	// scanned as one
+	s := scanner.New("", []byte("v = "+n.Raw), 0)
+	s.Scan()
+	s.Scan()
+	switch _, tok, _ := s.Scan(); tok {
+	case scanner.INT:
+		return reflect.TypeOf(int64(0))
+	case scanner.DOUBLE:
+		return reflect.TypeOf(float64(0))
+	case scanner.BOOL:
+		return reflect.TypeOf(false)
 	}
-	return nil
+	return reflect.TypeOf("")
 }
 
-func (p *Parser) parseInclude(v interface{}) error {
-	var buf = bytes.NewBuffer(nil)
+// isGroupType reports whether t is a struct or a pointer to one
+func isGroupType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
 
-	for {
-		b := p.getNext()
+// isElemType reports whether array items and map entries can be decoded
+// into values of type t
+func isElemType(t reflect.Type) bool {
+	if isScalarKind(t.Kind()) || isGroupType(t) || implementsUnmarshaler(t) {
+		return true
+	}
 
-		if b == 0 || b == 10 {
-			p.end = p.actual - 1
-			p.reportErr("No include specified")
-			return nil
-		}
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		return true
+	}
 
-		buf.WriteByte(b)
+	switch t.Kind() {
+	case reflect.Slice:
+		return isElemType(t.Elem())
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String && isElemType(t.Elem())
+	}
+	return false
+}
 
-		if buf.String() == "include" {
-			buf.Reset()
-			for {
-				b = p.getNext()
+// element decodes the node n into a new value of type typ. It reports
+// false if that fails
+func (d *decodeState) element(n *Node, typ reflect.Type, key string, opts tagOptions) (reflect.Value, bool) {
+	elem := reflect.New(typ).Elem()
+	errs := len(d.errs)
+	d.value(n, elem, key, opts)
+	return elem, len(d.errs) == errs
+}
 
-				if b == 0 {
-					break
-				}
+// array stores the items of the array n in the slice v
+func (d *decodeState) array(n *Node, v reflect.Value, key string, opts tagOptions) {
+	// Named groups are items of arrays of groups too, in the order
+	// they're written in. Their names are dropped
+	named := n.Kind == MapNode && v.Kind() == reflect.Slice && isGroupType(v.Type().Elem())
+	if v.Kind() != reflect.Slice || (n.Kind != ArrayNode && !named) {
+		d.typeErr(n, key, v.Type(), nil)
+		return
+	}
 
-				if isWhiteSpace(b) {
-					continue
-				}
+	elemType := v.Type().Elem()
+	if !isElemType(elemType) {
+		d.error(n, ArrayIncorrectType, key, "invalid array element type "+elemType.String(), nil)
+		return
+	}
 
-				if !isAlNum(b) {
-					if b == '.' || b == '/' || b == '\\' {
-						buf.WriteByte(b)
-						continue
-					}
-					break
-				}
-				buf.WriteByte(b)
-			}
-			break
+	slice := reflect.MakeSlice(v.Type(), 0, len(n.Children))
+	for i, item := range n.Children {
+		itemKey := joinKey(key, strconv.Itoa(i))
+		if named {
+			itemKey = joinKey(key, item.Key)
+		}
+		if elem, ok := d.element(item, elemType, itemKey, opts); ok {
+			slice = reflect.Append(slice, elem)
 		}
 	}
-	f, err := ioutil.ReadFile(buf.String())
-	if err != nil {
-		p.end = p.actual
-		p.reportErr("Couldn't open file '" + buf.String() + "'")
-		return err
-	}
-	return Unmarshal(f, v)
+	v.Set(slice)
 }
 
-func (p *Parser) unmarshal(v interface{}) error {
-	// While the current byte is not 0, we advance through the buffer
-	for b := p.getNext(); b != 0; b = p.getNext() {
+// keyValuePair stores the entries of the map n in the map v. A nil map
+// is allocated first, entries already in v are kept
+func (d *decodeState) keyValuePair(n *Node, v reflect.Value, key string, opts tagOptions) {
+	if n.Kind == ArrayNode && len(n.Children) > 0 {
+		d.typeErr(n, key, v.Type(), nil)
+		return
+	}
 
-		if b == 0 {
-			break
-		}
+	if v.Type().Key().Kind() != reflect.String {
+		d.error(n, ArrayIncorrectType, key, "invalid map key type "+v.Type().Key().String(), nil)
+		return
+	}
 
-		if b == 10 {
-			continue
-		}
+	elemType := v.Type().Elem()
+	if !isElemType(elemType) {
+		d.error(n, ArrayIncorrectType, key, "invalid map value type "+elemType.String(), nil)
+		return
+	}
 
-		// Ignore all initial whitespace
-		if isWhiteSpace(b) {
-			continue
-		}
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
 
-		// Consume comments
-		if b == '#' {
-			p.consumeComment()
-			continue
+	// Groups with the same name are merged, the way they are outside
+	// of arrays
+	groups := make(map[string]reflect.Value)
+	for _, entry := range n.Children {
+		elem, seen := groups[entry.Key]
+		ok := true
+		if seen && entry.Kind == GroupNode {
+			errs := len(d.errs)
+			d.value(entry, elem, joinKey(key, entry.Key), opts)
+			ok = len(d.errs) == errs
+		} else {
+			elem, ok = d.element(entry, elemType, joinKey(key, entry.Key), opts)
+		}
+		if entry.Kind == GroupNode {
+			groups[entry.Key] = elem
 		}
 
-		// If we encounter a letter assume that it's an identifier with a value
-		if isAlpha(b) {
-			p.buf.UnreadByte()
-			p.actual--
-			err := p.parseIdent(reflect.ValueOf(v))
-			if err != nil {
-				return err
-			}
+		if ok {
+			v.SetMapIndex(reflect.ValueOf(entry.Key).Convert(v.Type().Key()), elem)
 		}
+	}
+}
 
-		if b == '%' {
-			p.start = p.actual - 1
-			err := p.parseInclude(v)
-			if err != nil {
-				return err
-			}
+// unmarshal parses src and stores it in v. Unless opts.all is set
+// nothing is decoded from a source with syntax errors
+func unmarshal(filename string, src []byte, v interface{}, opts *decodeOptions) ErrorList {
+	// Make sure the supplied type is a pointer
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ErrorList{hasToBePtrTypeError(v)}
+	}
+
+	if opts.maxSize > 0 && int64(len(src)) > opts.maxSize {
+		return ErrorList{&SyntaxError{
+			Pos:  Position{Filename: filename},
+			Kind: SourceTooLarge,
+			Msg:  fmt.Sprintf("source is larger than %d bytes", opts.maxSize),
+		}}
+	}
+
+	p := newTreeParser(filename, src)
+	if opts.resolver != nil {
+		p.resolver = opts.resolver
+	}
+	p.maxSize = opts.maxSize
+	if opts.includeLimits {
+		p.maxDepth, p.maxFiles = opts.maxDepth, opts.maxFiles
+	}
+	if opts.includeRoot != "" {
+		root, err := filepath.Abs(opts.includeRoot)
+		if err != nil {
+			return ErrorList{err}
 		}
+		p.includeRoot = root
 	}
-	return nil
+	p.parse()
+	if len(p.errs) > 0 && !opts.all {
+		return p.errs
+	}
+
+	d := &decodeState{decodeOptions: opts, errs: p.errs}
+	d.value(p.root, rv, "", "")
+	if _, _, v := indirect(rv); opts.envOverlay && v.Kind() == reflect.Struct {
+		d.overlay(v, "")
+	}
+	return d.errs
 }
 
 // Unmarshal decodes a Pure source into a golang struct
+//
+// Syntax problems are returned as a *SyntaxError and values that
+// don't fit their field as a *DecodeError, both carrying the
+// position in the source
 func Unmarshal(src []byte, v interface{}) error {
-	parser := newParser(src)
+	if errs := unmarshal("", src, v, &decodeOptions{}); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
 
-	// Make sure the supplied type is a pointer
-	if reflect.ValueOf(v).Kind() != reflect.Ptr {
-		return hasToBePtrTypeError(v)
+// UnmarshalAll works like Unmarshal, but doesn't stop at the first
+// problem. Parsing resumes on the next line and every error found in
+// the source is returned in an ErrorList
+func UnmarshalAll(src []byte, v interface{}) error {
+	return unmarshal("", src, v, &decodeOptions{all: true}).Err()
+}
+
+// UnmarshalFile reads and decodes the Pure file filename. Positions
+// in errors and relative paths refer to the file
+func UnmarshalFile(filename string, v interface{}) error {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	if errs := unmarshal(filename, src, v, &decodeOptions{}); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// UnmarshalFS reads and decodes the file name from fsys. Files it
+// includes are read from fsys too
+func UnmarshalFS(fsys fs.FS, name string, v interface{}) error {
+	src, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
 	}
 
-	return parser.unmarshal(v)
+	opts := &decodeOptions{resolver: FSResolver(fsys)}
+	if errs := unmarshal(name, src, v, opts); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
 }
 
 func hasToBePtrTypeError(v interface{}) error {
-	return fmt.Errorf("%s has to be of pointer type\n", reflect.ValueOf(v).Type().Name())
+	return fmt.Errorf("pure: %s has to be of pointer type", reflect.TypeOf(v))
 }
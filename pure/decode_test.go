@@ -0,0 +1,359 @@
+package pure
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPathInFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"conf/app.pure":   {Data: []byte("dir = ./data\nfile = ./data/x\nmissing = ./nope\n")},
+		"conf/data/x":     {Data: []byte("x")},
+		"conf/other.pure": {Data: []byte("dir = ./data/x\n")},
+	}
+
+	var v struct {
+		Dir     string `pure:"dir,path,dir"`
+		File    string `pure:"file,path,exists"`
+		Missing string `pure:"missing,path"`
+	}
+	if err := UnmarshalFS(fsys, "conf/app.pure", &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Dir != "conf/data" || v.File != "conf/data/x" || v.Missing != "conf/nope" {
+		t.Errorf("got %+v", v)
+	}
+
+	var derr *DecodeError
+	err := UnmarshalFS(fsys, "conf/other.pure", &v)
+	if !errors.As(err, &derr) || derr.Kind != NotADirectory {
+		t.Errorf("got %v, want a %s error", err, NotADirectory)
+	}
+}
+
+func TestUnresolvedReference(t *testing.T) {
+	tests := []struct {
+		src  string
+		errs int
+	}{
+		{"a = 1\nb => nope\n", 1},
+		{"b => c\nc => b\n", 1},
+	}
+
+	for _, test := range tests {
+		var v struct {
+			A int `pure:"a"`
+			B int `pure:"b"`
+			C int `pure:"c"`
+		}
+		err := UnmarshalAll([]byte(test.src), &v)
+		var list ErrorList
+		if !errors.As(err, &list) || len(list) != test.errs {
+			t.Errorf("%q: got %v, want %d errors", test.src, err, test.errs)
+		}
+	}
+}
+
+func TestUnexportedField(t *testing.T) {
+	var v struct {
+		a int `pure:"a"`
+		B int `pure:"b"`
+	}
+	if err := Unmarshal([]byte("a = 1\nb = 2\n"), &v); err != nil || v.a != 0 || v.B != 2 {
+		t.Errorf("got %v, %+v", err, v)
+	}
+
+	dec := NewDecoder(strings.NewReader("a = 1\n"))
+	dec.DisallowUnknownKeys()
+	var derr *DecodeError
+	if err := dec.Decode(&v); !errors.As(err, &derr) || derr.Kind != UnexpectedKey {
+		t.Errorf("got %v, want an %s error", err, UnexpectedKey)
+	}
+
+	b, err := Marhsal(&v)
+	if err != nil || string(b) != "b = 2\n" {
+		t.Errorf("Marhsal: got %q, %v", b, err)
+	}
+}
+
+func TestArrayBareWords(t *testing.T) {
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{"a = [\n    hello\n    world\n]\n", []interface{}{"hello", "world"}},
+		{"a = [\n    hello\n\n    # comment\n]\n", []interface{}{"hello"}},
+		{"a = [\n    group\n        int = 1\n]\n", map[string]interface{}{"group": map[string]interface{}{"int": int64(1)}}},
+		{"a = [\n    group\n\n        # comment\n        int = 1\n]\n", map[string]interface{}{"group": map[string]interface{}{"int": int64(1)}}},
+	}
+
+	for _, test := range tests {
+		var v map[string]interface{}
+		if err := Unmarshal([]byte(test.src), &v); err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(v["a"], test.want) {
+			t.Errorf("%q: got %#v, want %#v", test.src, v["a"], test.want)
+		}
+	}
+}
+
+func TestArrayOfNamedGroups(t *testing.T) {
+	src := "servers = [\n    beta\n        host = \"b\"\n    alpha\n        host = \"a\"\n        port = 1\n]\n"
+
+	var s struct {
+		Servers []server `pure:"servers"`
+	}
+	if err := Unmarshal([]byte(src), &s); err != nil {
+		t.Fatal(err)
+	}
+	if want := []server{{"b", 0}, {"a", 1}}; !reflect.DeepEqual(s.Servers, want) {
+		t.Errorf("got %+v, want %+v", s.Servers, want)
+	}
+
+	var p struct {
+		Servers []*server `pure:"servers"`
+	}
+	if err := Unmarshal([]byte(src), &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Servers) != 2 || *p.Servers[0] != (server{"b", 0}) || *p.Servers[1] != (server{"a", 1}) {
+		t.Errorf("got %+v", p.Servers)
+	}
+
+	// Items with the same name are items of their own
+	dup := "servers = [\n    web\n        host = \"a\"\n    web\n        port = 2\n]\n"
+	s.Servers = nil
+	if err := Unmarshal([]byte(dup), &s); err != nil {
+		t.Fatal(err)
+	}
+	if want := []server{{"a", 0}, {"", 2}}; !reflect.DeepEqual(s.Servers, want) {
+		t.Errorf("got %+v, want %+v", s.Servers, want)
+	}
+
+	// and are merged in maps, like groups outside of arrays
+	var m struct {
+		Servers map[string]server `pure:"servers"`
+	}
+	if err := Unmarshal([]byte(dup), &m); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]server{"web": {"a", 2}}; !reflect.DeepEqual(m.Servers, want) {
+		t.Errorf("got %+v, want %+v", m.Servers, want)
+	}
+
+	var i struct {
+		Ints []int `pure:"ints"`
+	}
+	var derr *DecodeError
+	if err := Unmarshal([]byte("ints = [\n    a = 1\n]\n"), &i); !errors.As(err, &derr) {
+		t.Errorf("got %v, want a decode error", err)
+	}
+}
+
+func TestTrailingComments(t *testing.T) {
+	src := "i = 5 # five\nf = 1.5\t# float\nb = true # yes\nq = 5m # meters\nu = a \\# b # c\nc = #fff\ns = \"x\" # c\na = [1, 2] # c\n"
+	var v struct {
+		I int      `pure:"i"`
+		F float64  `pure:"f"`
+		B bool     `pure:"b"`
+		Q Quantity `pure:"q"`
+		U string   `pure:"u,unquoted"`
+		C string   `pure:"c,unquoted"`
+		S string   `pure:"s"`
+		A []int    `pure:"a"`
+	}
+	if err := Unmarshal([]byte(src), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.I != 5 || v.F != 1.5 || !v.B || v.Q.String() != "5m" || v.U != "a # b" || v.C != "#fff" || v.S != "x" || len(v.A) != 2 {
+		t.Errorf("got %+v", v)
+	}
+}
+
+func TestParseNumbers(t *testing.T) {
+	var (
+		int8Type    = reflect.TypeOf(int8(0))
+		int64Type   = reflect.TypeOf(int64(0))
+		intType     = reflect.TypeOf(0)
+		uint8Type   = reflect.TypeOf(uint8(0))
+		uint16Type  = reflect.TypeOf(uint16(0))
+		uint64Type  = reflect.TypeOf(uint64(0))
+		float32Type = reflect.TypeOf(float32(0))
+		float64Type = reflect.TypeOf(float64(0))
+	)
+
+	tests := []struct {
+		val  string
+		typ  reflect.Type
+		want interface{}
+		err  ErrorKind // UnknownError for none, ValueIncorrectType for syntax errors
+	}{
+		{"127", int8Type, int64(127), UnknownError},
+		{"-128", int8Type, int64(-128), UnknownError},
+		{"128", int8Type, nil, NumberTooLarge},
+		{"-129", int8Type, nil, NumberTooLarge},
+		{"9223372036854775807", int64Type, int64(9223372036854775807), UnknownError},
+		{"-9223372036854775808", int64Type, int64(-9223372036854775808), UnknownError},
+		{"9223372036854775808", int64Type, nil, NumberTooLarge},
+		{"1e3", intType, int64(1000), UnknownError},
+		{"1.5e1", intType, int64(15), UnknownError},
+		{"2.0", int8Type, int64(2), UnknownError},
+		{"1e3", int8Type, nil, NumberTooLarge},
+		{"1e19", int64Type, nil, NumberTooLarge},
+		{"1.5", intType, nil, RoundedDecimal},
+		{"0x10", intType, nil, ValueIncorrectType},
+		{"1/2", intType, nil, ValueIncorrectType},
+		{"abc", intType, nil, ValueIncorrectType},
+		{"255", uint8Type, uint64(255), UnknownError},
+		{"0", uint8Type, uint64(0), UnknownError},
+		{"256", uint8Type, nil, NumberTooLarge},
+		{"-1", uint8Type, nil, NumberTooLarge},
+		{"18446744073709551615", uint64Type, uint64(18446744073709551615), UnknownError},
+		{"18446744073709551616", uint64Type, nil, NumberTooLarge},
+		{"6.5e4", uint16Type, uint64(65000), UnknownError},
+		{"1.5", uint16Type, nil, RoundedDecimal},
+		{"1e5", uint16Type, nil, NumberTooLarge},
+		{"0.1", float32Type, float64(float32(0.1)), UnknownError},
+		{"3.4028235e38", float32Type, float64(float32(3.4028235e38)), UnknownError},
+		{"3.4028234663852886e38", float32Type, nil, RoundedDecimal},
+		{"1e39", float32Type, nil, NumberTooLarge},
+		{"16777217", float32Type, nil, RoundedDecimal},
+		{"1e39", float64Type, 1e39, UnknownError},
+		{"1e309", float64Type, nil, NumberTooLarge},
+		{"0.30000000000000004", float64Type, 0.30000000000000004, UnknownError},
+		{"0.30000000000000000001", float64Type, nil, RoundedDecimal},
+		{"x", float64Type, nil, ValueIncorrectType},
+	}
+
+	for _, test := range tests {
+		var got interface{}
+		var err error
+		switch test.typ.Kind() {
+		case reflect.Int8, reflect.Int, reflect.Int64:
+			got, err = parseInt(test.val, test.typ)
+		case reflect.Uint8, reflect.Uint16, reflect.Uint64:
+			got, err = parseUint(test.val, test.typ)
+		default:
+			got, err = parseFloat(test.val, test.typ)
+		}
+
+		kind := UnknownError
+		if rerr, ok := err.(*rangeError); ok {
+			kind = rerr.kind
+		} else if err != nil {
+			kind = ValueIncorrectType
+		}
+		switch {
+		case kind != test.err:
+			t.Errorf("%s into %s: got error %v, want %s", test.val, test.typ, err, test.err)
+		case err == nil && got != test.want:
+			t.Errorf("%s into %s: got %v, want %v", test.val, test.typ, got, test.want)
+		}
+	}
+}
+
+func TestDecodeNumbers(t *testing.T) {
+	var v struct {
+		C  complex128         `pure:"c"`
+		C2 complex64          `pure:"c2"`
+		A  []int8             `pure:"a"`
+		M  map[string]uint8   `pure:"m"`
+		F  []float32          `pure:"f"`
+		I  map[string]float32 `pure:"i"`
+	}
+	src := "c = (1+2i)\nc2 = 1e39\na = [1, 300, 2, 1e2]\nm = [\n    x = 1\n    y = 256\n]\nf = [1e39, 0.5]\ni = [\n    z = 1.5\n]\n"
+
+	// Items that don't fit are left out, and reported
+	err := UnmarshalAll([]byte(src), &v)
+	var list ErrorList
+	if !errors.As(err, &list) || len(list) != 4 {
+		t.Fatalf("got %v, want 4 errors", err)
+	}
+	for i, key := range []string{"c2", "a.1", "m.y", "f.0"} {
+		var derr *DecodeError
+		if !errors.As(list[i], &derr) || derr.Kind != NumberTooLarge || derr.Key != key {
+			t.Errorf("got %v, want a %s error for %s", list[i], NumberTooLarge, key)
+		}
+	}
+
+	if v.C != 1+2i || v.C2 != 0 {
+		t.Errorf("got c = %v, c2 = %v", v.C, v.C2)
+	}
+	if want := []int8{1, 2, 100}; !reflect.DeepEqual(v.A, want) {
+		t.Errorf("got %v, want %v", v.A, want)
+	}
+	if want := map[string]uint8{"x": 1}; !reflect.DeepEqual(v.M, want) {
+		t.Errorf("got %v, want %v", v.M, want)
+	}
+	if want := []float32{0.5}; !reflect.DeepEqual(v.F, want) {
+		t.Errorf("got %v, want %v", v.F, want)
+	}
+	if want := map[string]float32{"z": 1.5}; !reflect.DeepEqual(v.I, want) {
+		t.Errorf("got %v, want %v", v.I, want)
+	}
+}
+
+// errReader fails every read, for input that must not be read
+type errReader struct{}
+
+func (errReader) Read(p []byte) (int, error) {
+	return 0, errors.New("read past the limit")
+}
+
+func TestDecoderMaxSize(t *testing.T) {
+	fsys := fstest.MapFS{
+		"small.pure": {Data: []byte("b = 2\n")},                            // 6 bytes
+		"large.pure": {Data: []byte("b = 2\nc = 33\nd = 444\ne = 5555\n")}, // 30 bytes
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		max  int64
+		err  ErrorKind // UnknownError for none
+		msg  string
+	}{
+		{"no limit", "a = 1\n%include large.pure\n", 0, UnknownError, ""},
+		{"at the limit", "a = 1\n", 6, UnknownError, ""},
+		{"over the limit", "a = 10\n", 6, SourceTooLarge, "source is larger than 6 bytes"},
+		{"include at the limit", "%include small.pure\n", 20, UnknownError, ""},
+		{"include over the limit", "%include large.pure\n", 20, SourceTooLarge, `included file "large.pure" is larger than 20 bytes`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var v map[string]interface{}
+			dec := NewDecoder(strings.NewReader(test.src))
+			dec.SetIncludeResolver(FSResolver(fsys))
+			dec.SetMaxSize(test.max)
+			err := dec.Decode(&v)
+
+			if test.err == UnknownError {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			var serr *SyntaxError
+			if !errors.As(err, &serr) || serr.Kind != test.err || serr.Msg != test.msg {
+				t.Errorf("got %v, want %s error %q", err, test.err, test.msg)
+			}
+		})
+	}
+
+	// Reading stops as soon as the input is over the limit
+	var v map[string]interface{}
+	dec := NewDecoder(io.MultiReader(strings.NewReader("a = 1\nb = 2\n"), errReader{}))
+	dec.SetMaxSize(8)
+	var serr *SyntaxError
+	if err := dec.Decode(&v); !errors.As(err, &serr) || serr.Kind != SourceTooLarge {
+		t.Errorf("got %v, want a %s error", err, SourceTooLarge)
+	}
+}
@@ -0,0 +1,357 @@
+package pure
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// NodeKind tells what a Node in a Document holds
+type NodeKind int
+
+const (
+	GroupNode NodeKind = iota
+	PropertyNode
+	ArrayNode
+	MapNode
+)
+
+func (k NodeKind) String() string {
+	switch k {
+	case GroupNode:
+		return "group"
+	case PropertyNode:
+		return "property"
+	case ArrayNode:
+		return "array"
+	case MapNode:
+		return "map"
+	}
+	return "NodeKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Node is a group, property, array or map in a Document.
+// Array items are nodes without a key
+type Node struct {
+	Kind NodeKind
+
+	// Key of the node in its parent, empty for the root and array items
+	Key string
+
+	// Raw value of a property exactly as written in the source,
+	// quotes included
+	Raw string
+
+	// Key path a reference ('=>') points to. The node holds a copy of
+	// the referenced value
+	Ref string
+
+	// Members of a group or map and items of an array, in source order
+	Children []*Node
+
+	Pos Position
+
+	// Where the node is written in the source of its Document. Nil for
+	// groups that are only named in dotted keys, nodes from included
+	// files and copies made by references
+	span *span
+}
+
+// Document is a parsed Pure source. It keeps the source around so it
+// can be edited without losing comments and formatting
+type Document struct {
+	Root *Node
+
+	filename string
+	src      []byte
+
+	// Included files, read once so edits don't read them again
+	includes *includeCache
+}
+
+// Parse parses a Pure source into a Document. Its includes are read
+// relative to the working directory, once
+func Parse(src []byte) (*Document, error) {
+	return parseDocument("", src, newIncludeCache())
+}
+
+// Load reads and parses the Pure file filename
+func Load(filename string) (*Document, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseDocument(filename, src, newIncludeCache())
+}
+
+func parseDocument(filename string, src []byte, includes *includeCache) (*Document, error) {
+	p := newTreeParser(filename, src)
+	p.resolver = includes
+	p.parse()
+	if len(p.errs) > 0 {
+		return nil, p.errs[0]
+	}
+	return &Document{Root: p.root, filename: filename, src: src, includes: includes}, nil
+}
+
+// includeCache reads included files from the file system, each one
+// only once
+type includeCache struct {
+	files map[string]cachedRead
+	globs map[string][]string
+}
+
+type cachedRead struct {
+	src []byte
+	err error
+}
+
+func newIncludeCache() *includeCache {
+	return &includeCache{files: make(map[string]cachedRead), globs: make(map[string][]string)}
+}
+
+func (c *includeCache) ReadFile(name string) ([]byte, error) {
+	r, ok := c.files[name]
+	if !ok {
+		r.src, r.err = osResolver{}.ReadFile(name)
+		c.files[name] = r
+	}
+	return r.src, r.err
+}
+
+func (c *includeCache) Glob(pattern string) ([]string, error) {
+	matches, ok := c.globs[pattern]
+	if !ok {
+		var err error
+		if matches, err = (osResolver{}).Glob(pattern); err != nil {
+			return nil, err
+		}
+		c.globs[pattern] = matches
+	}
+	return matches, nil
+}
+
+// Get returns the node at the dotted key path, or nil if there is none.
+// Array items are addressed by their index, e.g. "array.0"
+func (d *Document) Get(path string) *Node {
+	return d.Root.Get(path)
+}
+
+// Has reports whether the document has a node at path
+func (d *Document) Has(path string) bool {
+	return d.Root.Has(path)
+}
+
+// Keys returns the top-level keys in source order
+func (d *Document) Keys() []string {
+	return d.Root.Keys()
+}
+
+// GetString returns the string value of the property at path
+func (d *Document) GetString(path string) (string, error) {
+	n, err := d.property(path)
+	if err != nil {
+		return "", err
+	}
+	str, err := n.StringValue()
+	return str, withKey(err, path)
+}
+
+// GetInt returns the integer value of the property at path
+func (d *Document) GetInt(path string) (int64, error) {
+	n, err := d.property(path)
+	if err != nil {
+		return 0, err
+	}
+	i, err := n.IntValue()
+	return i, withKey(err, path)
+}
+
+// GetFloat returns the floating point value of the property at path
+func (d *Document) GetFloat(path string) (float64, error) {
+	n, err := d.property(path)
+	if err != nil {
+		return 0, err
+	}
+	f, err := n.FloatValue()
+	return f, withKey(err, path)
+}
+
+// GetBool returns the boolean value of the property at path
+func (d *Document) GetBool(path string) (bool, error) {
+	n, err := d.property(path)
+	if err != nil {
+		return false, err
+	}
+	b, err := n.BoolValue()
+	return b, withKey(err, path)
+}
+
+func (d *Document) property(path string) (*Node, error) {
+	n := d.Get(path)
+	if n == nil {
+		return nil, &DecodeError{
+			Kind: KeyNotFound,
+			Key:  path,
+			Msg:  "key not found",
+		}
+	}
+
+	if n.Kind != PropertyNode {
+		return nil, &DecodeError{
+			Pos:  n.Pos,
+			Kind: ValueIncorrectType,
+			Key:  path,
+			Msg:  fmt.Sprintf("%s isn't a property", n.Kind),
+		}
+	}
+	return n, nil
+}
+
+// withKey sets the full key path on errors returned by Node accessors
+func withKey(err error, path string) error {
+	if derr, ok := err.(*DecodeError); ok {
+		derr.Key = path
+	}
+	return err
+}
+
+// Child returns the direct child with the given key, or nil
+func (n *Node) Child(key string) *Node {
+	if n == nil {
+		return nil
+	}
+
+	for _, c := range n.Children {
+		if c.Key == key && c.Key != "" {
+			return c
+		}
+	}
+	return nil
+}
+
+// Get returns the node at the dotted key path relative to n, or nil
+func (n *Node) Get(path string) *Node {
+	if path == "" {
+		return n
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		if n == nil {
+			return nil
+		}
+
+		if n.Kind == ArrayNode {
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(n.Children) {
+				return nil
+			}
+			n = n.Children[i]
+			continue
+		}
+		n = n.Child(key)
+	}
+	return n
+}
+
+// Has reports whether there is a node at path relative to n
+func (n *Node) Has(path string) bool {
+	return n.Get(path) != nil
+}
+
+// Keys returns the keys of a group or map in source order
+func (n *Node) Keys() []string {
+	if n == nil || (n.Kind != GroupNode && n.Kind != MapNode) {
+		return nil
+	}
+
+	keys := make([]string, 0, len(n.Children))
+	for _, c := range n.Children {
+		keys = append(keys, c.Key)
+	}
+	return keys
+}
+
+// StringValue returns the value of a property as a string, with
+// quotes removed and escapes resolved
+func (n *Node) StringValue() (string, error) {
+	if err := n.checkProperty("string"); err != nil {
+		return "", err
+	}
+	return unquote(n.Raw), nil
+}
+
+// IntValue returns the value of a property as an integer
+func (n *Node) IntValue() (int64, error) {
+	if err := n.checkProperty("int"); err != nil {
+		return 0, err
+	}
+
+	i, err := strconv.ParseInt(n.Raw, 10, 64)
+	if err != nil {
+		return 0, n.typeErr("int64", err)
+	}
+	return i, nil
+}
+
+// FloatValue returns the value of a property as a floating point number
+func (n *Node) FloatValue() (float64, error) {
+	if err := n.checkProperty("float"); err != nil {
+		return 0, err
+	}
+
+	f, err := strconv.ParseFloat(n.Raw, 64)
+	if err != nil {
+		return 0, n.typeErr("float64", err)
+	}
+	return f, nil
+}
+
+// BoolValue returns the value of a property as a boolean
+func (n *Node) BoolValue() (bool, error) {
+	if err := n.checkProperty("bool"); err != nil {
+		return false, err
+	}
+
+	b, err := strconv.ParseBool(strings.ToLower(n.Raw))
+	if err != nil {
+		return false, n.typeErr("bool", err)
+	}
+	return b, nil
+}
+
+func (n *Node) checkProperty(typ string) error {
+	if n.Kind == PropertyNode {
+		return nil
+	}
+	return &DecodeError{
+		Pos:  n.Pos,
+		Kind: ValueIncorrectType,
+		Key:  n.Key,
+		Msg:  fmt.Sprintf("cannot decode %s as %s", n.Kind, typ),
+	}
+}
+
+func (n *Node) typeErr(typ string, err error) error {
+	return &DecodeError{
+		Pos:   n.Pos,
+		Kind:  ValueIncorrectType,
+		Key:   n.Key,
+		Value: n.Raw,
+		Msg:   fmt.Sprintf("cannot decode %q as %s", n.Raw, typ),
+		Err:   err,
+	}
+}
+
+// copy returns a deep copy of n. The copy isn't written anywhere in
+// the source, so it has no span
+func (n *Node) copy() *Node {
+	c := *n
+	c.span = nil
+	c.Children = make([]*Node, len(n.Children))
+	for i, child := range n.Children {
+		c.Children[i] = child.copy()
+	}
+	return &c
+}
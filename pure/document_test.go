@@ -0,0 +1,127 @@
+package pure
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const documentSrc = `name = "app"
+bare = hello
+port = 8080
+ratio = 0.5
+debug = true
+server
+    host = "a"
+    limits.conns = 10
+ports = [80, 443]
+servers = [
+    web
+        host = "w"
+    db
+        host = "d"
+]
+alias => server.host
+`
+
+func TestDocumentGet(t *testing.T) {
+	d, err := Parse([]byte(documentSrc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"name", "bare", "port", "ratio", "debug", "server", "ports", "servers", "alias"}; !reflect.DeepEqual(d.Keys(), want) {
+		t.Errorf("Keys() = %v, want %v", d.Keys(), want)
+	}
+	if want := []string{"host", "limits"}; !reflect.DeepEqual(d.Get("server").Keys(), want) {
+		t.Errorf("server keys = %v, want %v", d.Get("server").Keys(), want)
+	}
+
+	tests := []struct {
+		path string
+		kind NodeKind
+		ok   bool
+	}{
+		{"", GroupNode, true},
+		{"name", PropertyNode, true},
+		{"server", GroupNode, true},
+		{"server.limits.conns", PropertyNode, true},
+		{"ports", ArrayNode, true},
+		{"ports.0", PropertyNode, true},
+		{"ports.1", PropertyNode, true},
+		{"ports.2", 0, false},
+		{"ports.-1", 0, false},
+		{"ports.x", 0, false},
+		{"servers", MapNode, true},
+		{"servers.db.host", PropertyNode, true},
+		{"servers.1", 0, false},
+		{"nope", 0, false},
+		{"name.x", 0, false},
+		{"server.nope", 0, false},
+	}
+	for _, test := range tests {
+		n := d.Get(test.path)
+		if d.Has(test.path) != test.ok || (n != nil) != test.ok {
+			t.Errorf("%q: got %v, want found = %v", test.path, n, test.ok)
+			continue
+		}
+		if test.ok && n.Kind != test.kind {
+			t.Errorf("%q: got a %s, want a %s", test.path, n.Kind, test.kind)
+		}
+	}
+}
+
+func TestDocumentValues(t *testing.T) {
+	d, err := Parse([]byte(documentSrc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := map[string]func(path string) (interface{}, error){
+		"string": func(path string) (interface{}, error) { return d.GetString(path) },
+		"int":    func(path string) (interface{}, error) { return d.GetInt(path) },
+		"float":  func(path string) (interface{}, error) { return d.GetFloat(path) },
+		"bool":   func(path string) (interface{}, error) { return d.GetBool(path) },
+	}
+
+	tests := []struct {
+		get  string
+		path string
+		want interface{}
+		err  ErrorKind // UnknownError for none
+	}{
+		{"string", "name", "app", UnknownError},
+		{"string", "bare", "hello", UnknownError},
+		{"string", "servers.web.host", "w", UnknownError},
+		{"string", "alias", "a", UnknownError},
+		{"int", "port", int64(8080), UnknownError},
+		{"int", "ports.1", int64(443), UnknownError},
+		{"int", "server.limits.conns", int64(10), UnknownError},
+		{"float", "ratio", 0.5, UnknownError},
+		{"float", "port", 8080.0, UnknownError},
+		{"bool", "debug", true, UnknownError},
+		{"int", "nope", nil, KeyNotFound},
+		{"int", "ports.5", nil, KeyNotFound},
+		{"string", "server", nil, ValueIncorrectType},
+		{"int", "ports", nil, ValueIncorrectType},
+		{"int", "name", nil, ValueIncorrectType},
+		{"int", "ratio", nil, ValueIncorrectType},
+		{"bool", "port", nil, ValueIncorrectType},
+		{"float", "debug", nil, ValueIncorrectType},
+	}
+
+	for _, test := range tests {
+		got, err := get[test.get](test.path)
+		if test.err == UnknownError {
+			if err != nil || got != test.want {
+				t.Errorf("%s %q: got %v, %v, want %v", test.get, test.path, got, err, test.want)
+			}
+			continue
+		}
+
+		var derr *DecodeError
+		if !errors.As(err, &derr) || derr.Kind != test.err || derr.Key != test.path {
+			t.Errorf("%s %q: got %v, want a %s error for %s", test.get, test.path, err, test.err, test.path)
+		}
+	}
+}
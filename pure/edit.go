@@ -0,0 +1,278 @@
+package pure
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Krognol/go-pure/pure/scanner"
+)
+
+// span is where a node is written in the source of a Document
+type span struct {
+	lineStart int    // Start of the line the node starts on
+	start     int    // Start of the node, its first key for members
+	end       int    // End of the value, or of the indented members of a group
+	op        int    // Offset of the '=' or '=>', -1 if there's none
+	val       int    // Offset of the value, -1 if there's none
+	line      bool   // Whether the node has its lines to itself
+	lead      string // Text before the last key on the line, e.g. "    agroup."
+}
+
+// splice replaces the source between start and end with text
+type splice struct {
+	start, end int
+	text       string
+}
+
+// lineEnd returns the offset of the line break at or after off
+func lineEnd(src []byte, off int) int {
+	i := bytes.IndexByte(src[off:], '\n')
+	if i < 0 {
+		return len(src)
+	}
+	if i > 0 && src[off+i-1] == '\r' {
+		i--
+	}
+	return off + i
+}
+
+// nextLine returns the offset of the line after the one off is on
+func nextLine(src []byte, off int) int {
+	if i := bytes.IndexByte(src[off:], '\n'); i >= 0 {
+		return off + i + 1
+	}
+	return len(src)
+}
+
+// Bytes returns the source of the document with all edits applied.
+// Everything that wasn't edited is kept as it was, comments and
+// blank lines included
+func (d *Document) Bytes() []byte {
+	return d.src
+}
+
+// SetValue replaces the value of the property or array at path with v.
+// Nodes taken from the document before the edit are no longer part of it
+func (d *Document) SetValue(path string, v interface{}) error {
+	n, err := d.node(path)
+	if err != nil {
+		return err
+	}
+
+	if n.Kind == GroupNode {
+		return editErr(n, path, "cannot set the value of a group")
+	}
+
+	if n.span == nil {
+		return editErr(n, path, notWritten)
+	}
+
+	val, err := formatValue(reflect.ValueOf(v))
+	if err != nil {
+		return err
+	}
+
+	// A reference is replaced by the value
+	if n.Ref != "" {
+		return d.apply(splice{start: n.span.op, end: n.span.end, text: "= " + val})
+	}
+	return d.apply(splice{start: n.span.val, end: n.span.end, text: val})
+}
+
+// Delete removes the node at path with everything in it. For groups
+// that are written in several places every one of them is removed
+func (d *Document) Delete(path string) error {
+	n, err := d.node(path)
+	if err != nil {
+		return err
+	}
+
+	if n == d.Root {
+		return editErr(n, path, "cannot delete the root")
+	}
+
+	var edits []splice
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if n.span != nil {
+			edits = append(edits, d.deletion(n.span))
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(n)
+
+	if len(edits) == 0 {
+		return editErr(n, path, notWritten)
+	}
+
+	// Members of a group or array are removed along with it
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	merged := edits[:1]
+	for _, e := range edits[1:] {
+		last := &merged[len(merged)-1]
+		if e.start < last.end {
+			if e.end > last.end {
+				last.end = e.end
+			}
+			continue
+		}
+		merged = append(merged, e)
+	}
+	return d.apply(merged...)
+}
+
+// InsertAfter adds key = v right after the node at path, in the same
+// group, map or array. Items are added to arrays with an empty key
+func (d *Document) InsertAfter(path, key string, v interface{}) error {
+	n, err := d.node(path)
+	if err != nil {
+		return err
+	}
+
+	if n.span == nil {
+		return editErr(n, path, notWritten)
+	}
+
+	parentPath := ""
+	if i := strings.LastIndexByte(path, '.'); i >= 0 {
+		parentPath = path[:i]
+	}
+	parent := d.Get(parentPath)
+
+	switch {
+	case parent.Kind == ArrayNode && key != "":
+		return editErr(n, path, "array items don't have a key")
+	case parent.Kind != ArrayNode && !isKey(key):
+		return editErr(n, path, fmt.Sprintf("invalid key %q", key))
+	case parent.Kind != ArrayNode && parent.Child(key) != nil:
+		return &DecodeError{
+			Pos:  parent.Child(key).Pos,
+			Kind: KeyAlreadyDefined,
+			Key:  joinKey(parentPath, key),
+			Msg:  "key is already defined",
+		}
+	}
+
+	text, err := formatValue(reflect.ValueOf(v))
+	if err != nil {
+		return err
+	}
+	if key != "" {
+		text = key + " = " + text
+	}
+
+	// Inline members are followed by a ',', others get their own line
+	sp := n.span
+	if !sp.line {
+		return d.apply(splice{start: sp.end, end: sp.end, text: ", " + text})
+	}
+
+	off := nextLine(d.src, sp.end)
+	text = sp.lead + text
+	if off == len(d.src) && (off == 0 || d.src[off-1] != '\n') {
+		text = d.newline() + text
+	} else {
+		text += d.newline()
+	}
+	return d.apply(splice{start: off, end: off, text: text})
+}
+
+const notWritten = "node isn't written in the document, it comes from an include or a reference"
+
+func editErr(n *Node, path, msg string) error {
+	return &DecodeError{
+		Pos:  n.Pos,
+		Kind: InvalidEdit,
+		Key:  path,
+		Msg:  msg,
+	}
+}
+
+// node returns the node at path, or a KeyNotFound error
+func (d *Document) node(path string) (*Node, error) {
+	n := d.Get(path)
+	if n == nil {
+		return nil, &DecodeError{
+			Kind: KeyNotFound,
+			Key:  path,
+			Msg:  "key not found",
+		}
+	}
+	return n, nil
+}
+
+// deletion returns the edit removing the node written at sp
+func (d *Document) deletion(sp *span) splice {
+	if sp.line {
+		return splice{start: sp.lineStart, end: nextLine(d.src, sp.end)}
+	}
+
+	// Inline items take the ',' after them along, or the one before
+	// them if they're last
+	end := sp.end
+	for end < len(d.src) && (d.src[end] == ' ' || d.src[end] == '\t') {
+		end++
+	}
+	if end < len(d.src) && d.src[end] == ',' {
+		end++
+		for end < len(d.src) && (d.src[end] == ' ' || d.src[end] == '\t') {
+			end++
+		}
+		return splice{start: sp.start, end: end}
+	}
+
+	start := sp.start
+	for start > 0 && (d.src[start-1] == ' ' || d.src[start-1] == '\t') {
+		start--
+	}
+	if start > 0 && d.src[start-1] == ',' {
+		return splice{start: start - 1, end: sp.end}
+	}
+	return splice{start: sp.start, end: sp.end}
+}
+
+// apply makes the edits, which mustn't overlap, and parses the result.
+// The document is left as it was if that fails
+func (d *Document) apply(edits ...splice) error {
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	var buf bytes.Buffer
+	off := 0
+	for _, e := range edits {
+		buf.Write(d.src[off:e.start])
+		buf.WriteString(e.text)
+		off = e.end
+	}
+	buf.Write(d.src[off:])
+
+	doc, err := parseDocument(d.filename, buf.Bytes(), d.includes)
+	if err != nil {
+		return err
+	}
+	*d = *doc
+	return nil
+}
+
+// newline returns the line break the document uses
+func (d *Document) newline() string {
+	if i := bytes.IndexByte(d.src, '\n'); i > 0 && d.src[i-1] == '\r' {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// isKey reports whether key can be written as a single key
+func isKey(key string) bool {
+	s := scanner.New("", []byte(key), 0)
+	_, tok, lit := s.Scan()
+	if tok != scanner.IDENTIFIER || lit != key {
+		return false
+	}
+	_, tok, _ = s.Scan()
+	return tok == scanner.EOF
+}
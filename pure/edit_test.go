@@ -0,0 +1,125 @@
+package pure
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDocumentEdits(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		edit func(d *Document) error
+		want string
+		err  ErrorKind // UnknownError for none
+	}{
+		{"set with comments", "# top\na = 1 # one\n\nb = \"x\"\n",
+			func(d *Document) error { return d.SetValue("a", 2) },
+			"# top\na = 2 # one\n\nb = \"x\"\n", UnknownError},
+		{"set reference", "a = 1\nr => a\n",
+			func(d *Document) error { return d.SetValue("r", 5) },
+			"a = 1\nr = 5\n", UnknownError},
+		{"set inline item", "arr = [1, 2, 3] # c\n",
+			func(d *Document) error { return d.SetValue("arr.1", 9) },
+			"arr = [1, 9, 3] # c\n", UnknownError},
+		{"set array", "a = 1\r\ng\r\n    b = 2\r\n",
+			func(d *Document) error { return d.SetValue("a", []int{1, 2}) },
+			"a = [1, 2]\r\ng\r\n    b = 2\r\n", UnknownError},
+		{"set indented member", "g\n    a = 1\n    b = 2\nz = 0\n",
+			func(d *Document) error { return d.SetValue("g.b", 2.5) },
+			"g\n    a = 1\n    b = 2.5\nz = 0\n", UnknownError},
+		{"delete first inline item", "arr = [1, 2, 3]\n",
+			func(d *Document) error { return d.Delete("arr.0") },
+			"arr = [2, 3]\n", UnknownError},
+		{"delete last inline item", "arr = [1, 2, 3]\n",
+			func(d *Document) error { return d.Delete("arr.2") },
+			"arr = [1, 2]\n", UnknownError},
+		{"delete dotted member", "g.a = 1\ng.b = 2\n",
+			func(d *Document) error { return d.Delete("g.b") },
+			"g.a = 1\n", UnknownError},
+		{"delete indented group", "g\n    a = 1\n    b = 2\nz = 0\n",
+			func(d *Document) error { return d.Delete("g") },
+			"z = 0\n", UnknownError},
+		{"insert inline item", "arr = [1, 2]\n",
+			func(d *Document) error { return d.InsertAfter("arr.1", "", 3) },
+			"arr = [1, 2, 3]\n", UnknownError},
+		{"insert item on its line", "arr = [\n    1\n    2\n]\n",
+			func(d *Document) error { return d.InsertAfter("arr.0", "", 5) },
+			"arr = [\n    1\n    5\n    2\n]\n", UnknownError},
+		{"insert dotted member", "g.a = 1\ng.b = 2\n",
+			func(d *Document) error { return d.InsertAfter("g.a", "c", "x") },
+			"g.a = 1\ng.c = \"x\"\ng.b = 2\n", UnknownError},
+		{"insert after comment", "g\n    a = 1 # c\n    b = 2\nz = 0\n",
+			func(d *Document) error { return d.InsertAfter("g.a", "c", true) },
+			"g\n    a = 1 # c\n    c = true\n    b = 2\nz = 0\n", UnknownError},
+		{"insert CRLF", "a = 1\r\ng\r\n    b = 2\r\n",
+			func(d *Document) error { return d.InsertAfter("g.b", "c", 3) },
+			"a = 1\r\ng\r\n    b = 2\r\n    c = 3\r\n", UnknownError},
+		{"insert map entry", "m = [\n    a = 1\n]\n",
+			func(d *Document) error { return d.InsertAfter("m.a", "b", 2) },
+			"m = [\n    a = 1\n    b = 2\n]\n", UnknownError},
+		{"missing key", "a = 1\n",
+			func(d *Document) error { return d.SetValue("nope", 2) },
+			"a = 1\n", KeyNotFound},
+		{"set group", "g\n    a = 1\n",
+			func(d *Document) error { return d.SetValue("g", 2) },
+			"g\n    a = 1\n", InvalidEdit},
+		{"invalid key", "a = 1\n",
+			func(d *Document) error { return d.InsertAfter("a", "bad key", 2) },
+			"a = 1\n", InvalidEdit},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := Parse([]byte(test.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = test.edit(d)
+			var derr *DecodeError
+			switch {
+			case test.err == UnknownError && err != nil:
+				t.Fatal(err)
+			case test.err != UnknownError && (!errors.As(err, &derr) || derr.Kind != test.err):
+				t.Fatalf("got %v, want a %s error", err, test.err)
+			}
+			if got := string(d.Bytes()); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDocumentEditIncludes(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.pure": "a = 1\n%include inc.pure\n",
+		"inc.pure":  "b = 2\n",
+	})
+
+	d, err := Load(filepath.Join(dir, "main.pure"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetValue("b", 3); err == nil {
+		t.Error("expected an error setting a key from an include")
+	}
+
+	// Included files are read when the document is loaded, not on
+	// every edit
+	if err := os.Remove(filepath.Join(dir, "inc.pure")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetValue("a", 5); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := d.GetInt("b"); err != nil || b != 2 {
+		t.Errorf("got b = %d, %v", b, err)
+	}
+	if got := string(d.Bytes()); got != "a = 5\n%include inc.pure\n" {
+		t.Errorf("got %q", got)
+	}
+}
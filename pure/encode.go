@@ -2,168 +2,602 @@ package pure
 
 import (
 	"bytes"
+	"encoding"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+)
+
+// Marshaler is implemented by types that encode their own Pure value.
+// MarshalPure returns the value as it's written after the '=', for
+// example a quoted string, a number or an array
+type Marshaler interface {
+	MarshalPure() ([]byte, error)
+}
+
+var (
+	marshalerType     = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// asInterface returns v, or a pointer to it, as a value of type iface
+func asInterface(v reflect.Value, iface reflect.Type) (interface{}, bool) {
+	if v.Type().Implements(iface) && v.CanInterface() {
+		return v.Interface(), true
+	}
+
+	if v.CanAddr() && reflect.PtrTo(v.Type()).Implements(iface) {
+		return v.Addr().Interface(), true
+	}
+	return nil, false
+}
+
+// deref walks down v through pointers and interfaces, stopping at the
+// first Marshaler or encoding.TextMarshaler. The value is invalid if
+// it ends at a nil pointer
+func deref(v reflect.Value) (Marshaler, encoding.TextMarshaler, reflect.Value) {
+	for {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return nil, nil, reflect.Value{}
+		}
+
+		if m, ok := asInterface(v, marshalerType); ok {
+			return m.(Marshaler), nil, v
+		}
+
+		if m, ok := asInterface(v, textMarshalerType); ok {
+			return nil, m.(encoding.TextMarshaler), v
+		}
+
+		if v.Kind() != reflect.Ptr && v.Kind() != reflect.Interface {
+			return nil, nil, v
+		}
+
+		if v.IsNil() {
+			return nil, nil, reflect.Value{}
+		}
+		v = v.Elem()
+	}
+}
+
+// GroupStyle is the way an Encoder writes groups
+type GroupStyle int
+
+const (
+	// IndentedGroups writes the key of a group on its own line,
+	// followed by its indented members
+	IndentedGroups GroupStyle = iota
+
+	// DotGroups writes every member of a group as group.key = value
+	DotGroups
 )
 
 type encoder struct {
 	buf         *bytes.Buffer
-	indentSize  int
+	indent      string
+	newline     string
+	style       GroupStyle
 	indentlevel int
 }
 
-func (e *encoder) group(v reflect.Value) {
-	var iv reflect.Value
-	if v.Kind() == reflect.Ptr {
-		iv = indirect(v.Elem())
-	} else if v.Kind() == reflect.Struct {
-		iv = indirect(v)
+func newEncoder() *encoder {
+	return &encoder{
+		buf:     &bytes.Buffer{},
+		indent:  "    ",
+		newline: "\n",
 	}
+}
 
-	for i := 0; i < iv.NumField(); i++ {
-		e.buf.WriteString("\r\n")
-		tag := iv.Type().Field(i).Tag.Get("pure")
+// line writes s on a line of its own at the current indentation
+func (e *encoder) line(s string) {
+	for i := 0; i < e.indentlevel; i++ {
+		e.buf.WriteString(e.indent)
+	}
+	e.buf.WriteString(s)
+	e.buf.WriteString(e.newline)
+}
 
-		if split := strings.Split(tag, ","); len(split) > 1 {
-			tag = split[0]
+// group writes the tagged fields of the struct v. With dot groups
+// every key is written after prefix
+func (e *encoder) group(v reflect.Value, prefix string) error {
+	for i := 0; i < v.NumField(); i++ {
+		tag, opts := parseTag(v.Type().Field(i).Tag.Get("pure"))
+		if tag == "" || tag == "-" || v.Type().Field(i).PkgPath != "" {
+			continue
 		}
 
-		if tag != "" && tag != "-" {
-			field := iv.Field(i)
-			for j := 0; j < e.indentSize*e.indentlevel; j++ {
-				e.buf.WriteByte(' ')
-			}
+		if err := e.member(prefix+tag, v.Field(i), opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			switch field.Kind() {
-			case reflect.Int, reflect.Float64, reflect.Bool:
-				e.buf.WriteString(fmt.Sprintf("%s = %v", tag, field))
-			case reflect.String:
-				e.buf.WriteString(fmt.Sprintf("%s = \"%v\"", tag, field))
-			case reflect.Ptr, reflect.Struct:
-				e.indentlevel++
-				e.buf.WriteString(tag)
-				e.group(field)
-				e.indentlevel--
-			case reflect.Slice:
-				e.buf.WriteString(fmt.Sprintf("%s = [", tag))
-				e.array(field)
-				e.buf.WriteString("\r\n]\r\n")
-			case reflect.Map:
-				e.buf.WriteString(fmt.Sprintf("%s = [", tag))
-				e.keyValuePair(field)
-				e.buf.WriteString("\r\n]\r\n")
-			}
+// member writes key with the value v, which is a property, a group,
+// an array or a map
+func (e *encoder) member(key string, v reflect.Value, opts tagOptions) error {
+	m, tm, v := deref(v)
+	switch {
+	case m != nil:
+		return e.marshaler(key+" = ", m)
+	case tm != nil:
+		val, err := e.text(tm, opts)
+		if err != nil {
+			return err
+		}
+		if opts.unquoted() {
+			val = commentEscaper.Replace(val)
+		}
+		e.line(key + " = " + val)
+		return nil
+	case !v.IsValid():
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if e.style == DotGroups {
+			return e.group(v, key+".")
 		}
+		e.line(key)
+		e.indentlevel++
+		err := e.group(v, "")
+		e.indentlevel--
+		return err
+	case reflect.Slice, reflect.Array:
+		e.line(key + " = [")
+		e.indentlevel++
+		err := e.array(v, opts)
+		e.indentlevel--
+		e.line("]")
+		return err
+	case reflect.Map:
+		e.line(key + " = [")
+		e.indentlevel++
+		err := e.keyValuePair(v, opts)
+		e.indentlevel--
+		e.line("]")
+		return err
 	}
-	e.buf.WriteString("\r\n")
+
+	val, err := e.scalar(v, opts)
+	if err != nil {
+		return err
+	}
+	e.line(key + " = " + val)
+	return nil
 }
 
-func (e *encoder) keyValuePair(v reflect.Value) {
+// keyValuePair writes the entries of the map v, sorted by key
+func (e *encoder) keyValuePair(v reflect.Value, opts tagOptions) error {
+	if v.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("pure: cannot encode map with %s keys", v.Type().Key())
+	}
+
 	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	for _, key := range keys {
+		if err := e.member(key.String(), v.MapIndex(key), opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshaler writes the value returned by m after prefix, or as an
+// array item without one. Lines after the first one are indented to
+// the current level
+func (e *encoder) marshaler(prefix string, m Marshaler) error {
+	b, err := m.MarshalPure()
+	if err != nil {
+		return err
+	}
+
+	// The value has to parse as exactly one value
+	valid := prefix == "" && arrayItem(string(b)) != nil
+	if prefix != "" {
+		p := newTreeParser("", append([]byte("v = "), b...))
+		p.parse()
+		valid = len(p.errs) == 0 && len(p.root.Children) == 1
+	}
+	if !valid {
+		return fmt.Errorf("pure: invalid value %q from MarshalPure of %T", b, m)
+	}
+
+	lines := strings.Split(strings.Replace(string(b), "\r\n", "\n", -1), "\n")
+	e.line(prefix + lines[0])
+	for _, l := range lines[1:] {
+		e.line(l)
+	}
+	return nil
+}
+
+// text returns the text of tm as a string value. Times are written
+// the way they are decoded
+func (e *encoder) text(tm encoding.TextMarshaler, opts tagOptions) (string, error) {
+	switch t := tm.(type) {
+	case time.Time:
+		return formatTime(t, opts.layout())
+	case *time.Time:
+		return formatTime(*t, opts.layout())
+	}
+
+	b, err := tm.MarshalText()
+	if err != nil {
+		return "", err
+	}
+
+	if opts.unquoted() {
+		return string(b), nil
+	}
+	return quoteString(string(b))
+}
+
+// commentEscaper keeps a '#' after whitespace in an unquoted value from
+// starting a comment
+var commentEscaper = strings.NewReplacer(" #", " \\#", "\t#", "\t\\#")
+
+// arrayItem returns the item s is read as when it's written alone on
+// a line of an array, or nil if it isn't read as exactly one item
+func arrayItem(s string) *Node {
+	p := newTreeParser("", []byte("v = [\n"+s+"\n]\n"))
+	p.parse()
+	if len(p.errs) > 0 || len(p.root.Children) != 1 {
+		return nil
+	}
+
+	arr := p.root.Children[0]
+	if arr.Kind != ArrayNode || len(arr.Children) != 1 {
+		return nil
+	}
+	return arr.Children[0]
+}
+
+// itemEscaper escapes what ends an unquoted array item
+var itemEscaper = strings.NewReplacer("\\", "\\\\", ",", "\\,", "]", "\\]", "#", "\\#")
+
+// unquotedItem returns s as an unquoted array item. Bytes that would
+// make it read back as something else, like a key, a comment or
+// several items, are escaped
+func unquotedItem(s string) (string, error) {
+	if s == "" || strings.ContainsAny(s, "\r\n") {
+		return quoteString(s)
+	}
+
+	item := itemEscaper.Replace(s)
+	if n := arrayItem(item); n == nil || n.Kind != PropertyNode || n.Raw != item {
+		item = "\\" + item
+	}
+
+	if n := arrayItem(item); n == nil || n.Kind != PropertyNode || n.Raw != item {
+		return "", fmt.Errorf("pure: cannot write %q as an unquoted array item", s)
+	}
+	return item, nil
+}
+
+// array writes the items of the slice v, one per line
+func (e *encoder) array(v reflect.Value, opts tagOptions) error {
 	for i := 0; i < v.Len(); i++ {
-		e.buf.WriteString("\r\n")
-		for i := 0; i < e.indentSize*e.indentlevel; i++ {
-			e.buf.WriteByte(' ')
-		}
-
-		key := keys[i]
-		val := v.MapIndex(key)
-		switch reflect.TypeOf(v.Interface()).Elem().Kind() {
-		case reflect.Int, reflect.Float64, reflect.Bool:
-			e.buf.WriteString(fmt.Sprintf("%v = %v", key, val))
-		case reflect.String:
-			e.buf.WriteString(fmt.Sprintf("%v = \"%v\"", key, val))
-		case reflect.Ptr, reflect.Struct:
-			e.indentlevel++
-			e.buf.WriteString(fmt.Sprintf("%v", key))
-			e.group(val)
-			e.indentlevel--
-		case reflect.Slice:
-			e.indentlevel++
-			e.buf.WriteString(fmt.Sprintf("%v = [", key))
-			e.array(val)
-			e.buf.WriteString("\n]\n")
-			e.indentlevel--
-		case reflect.Map:
+		m, tm, item := deref(v.Index(i))
+		switch {
+		case m != nil:
+			if err := e.marshaler("", m); err != nil {
+				return err
+			}
+			continue
+		case tm != nil:
+			val, err := e.text(tm, opts)
+			switch tm.(type) {
+			case time.Time, *time.Time:
+				// Written the way they're decoded already
+			default:
+				if err == nil && opts.unquoted() {
+					val, err = unquotedItem(val)
+				}
+			}
+			if err != nil {
+				return err
+			}
+			e.line(val)
+			continue
+		}
+
+		if !item.IsValid() {
+			return fmt.Errorf("pure: cannot encode nil item of %s", v.Type())
+		}
+
+		// Groups are written as arrays of their members
+		if item.Kind() == reflect.Struct {
+			e.line("[")
 			e.indentlevel++
-			e.buf.WriteString(fmt.Sprintf("%v = [", key))
-			e.keyValuePair(val)
-			e.buf.WriteString("\n]\n")
+			err := e.group(item, "")
 			e.indentlevel--
+			e.line("]")
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		val, err := e.scalar(item, opts)
+		if opts.unquoted() && item.Kind() == reflect.String {
+			val, err = unquotedItem(item.String())
 		}
+		if err != nil {
+			return err
+		}
+		e.line(val)
 	}
+	return nil
 }
 
-func (e *encoder) array(v reflect.Value) {
-
-	for i := 0; i < v.Len(); i++ {
-		e.buf.WriteString("\r\n")
-		for i := 0; i < e.indentSize*e.indentlevel; i++ {
-			e.buf.WriteByte(' ')
+// scalar returns the property value v as written in Pure
+func (e *encoder) scalar(v reflect.Value, opts tagOptions) (string, error) {
+	switch {
+	case opts.Contains("bytes"):
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return formatBytes(new(big.Rat).SetInt64(v.Int())), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return formatBytes(new(big.Rat).SetInt(new(big.Int).SetUint64(v.Uint()))), nil
 		}
+	case opts.Contains("duration") && v.Kind() == reflect.Int64, v.Type() == durationType:
+		return formatDuration(time.Duration(v.Int())), nil
+	}
 
-		switch reflect.TypeOf(v.Interface()).Elem().Kind() {
-		case reflect.Int, reflect.Float64, reflect.Bool:
-			e.buf.WriteString(fmt.Sprintf("%v", v.Index(i)))
-		case reflect.String:
-			e.buf.WriteString(fmt.Sprintf("\"%v\"", v.Index(i)))
+	switch v.Kind() {
+	case reflect.String:
+		// An empty value has to be quoted to be there at all
+		if opts.unquoted() && v.Len() > 0 {
+			return commentEscaper.Replace(v.String()), nil
 		}
+		return quoteString(v.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128, reflect.Bool:
+		return formatValue(v)
 	}
+	return "", fmt.Errorf("pure: cannot encode %s as a property", v.Type())
 }
 
 func (e *encoder) marshal(v interface{}) error {
-	iv := indirect(reflect.ValueOf(v))
-	var noQuotes bool
-	for i := 0; i < iv.NumField(); i++ {
-		tag := iv.Type().Field(i).Tag.Get("pure")
-
-		if split := strings.Split(tag, ","); len(split) > 1 {
-			tag = split[0]
-			if split[1] == "quantity" || split[1] == "path" || split[1] == "env" || split[1] == "unquoted" {
-				noQuotes = true
-			}
-		}
-		if tag != "" && tag != "-" {
-			field := iv.Field(i)
-
-			switch field.Kind() {
-			case reflect.Int, reflect.Float64, reflect.Bool:
-				e.buf.WriteString(fmt.Sprintf("%s = %v\n", tag, field))
-			case reflect.String:
-				if noQuotes {
-					e.buf.WriteString(fmt.Sprintf("%s = %v\n", tag, field))
-					noQuotes = false
-				} else {
-					e.buf.WriteString(fmt.Sprintf("%s = \"%v\"\n", tag, field))
-				}
-			case reflect.Ptr, reflect.Struct:
-				e.buf.WriteString(tag)
-				e.group(field)
-			case reflect.Slice:
-				e.buf.WriteString(fmt.Sprintf("%s = [", tag))
-				e.array(field)
-				e.buf.WriteString("\r\n]\r\n")
-			case reflect.Map:
-				e.buf.WriteString(fmt.Sprintf("%s = [", tag))
-				e.keyValuePair(field)
-				e.buf.WriteString("\r\n]\r\n")
-			}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return fmt.Errorf("pure: cannot encode nil %s", rv.Type())
 		}
+		rv = rv.Elem()
 	}
 
-	return nil
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("pure: cannot encode %s, only structs", rv.Type())
+	}
+	return e.group(rv, "")
 }
 
+// Marhsal returns the Pure encoding of the struct v, indented by four
+// spaces with "\n" line endings
 func Marhsal(v interface{}) ([]byte, error) {
-	e := &encoder{
-		buf:         &bytes.Buffer{},
-		indentSize:  4,
-		indentlevel: 1,
-	}
+	e := newEncoder()
 	err := e.marshal(v)
 	if err != nil {
 		return nil, err
 	}
 	return e.buf.Bytes(), nil
 }
+
+// An Encoder writes Pure sources to an output stream
+type Encoder struct {
+	w       io.Writer
+	width   int
+	tabs    bool
+	newline string
+	style   GroupStyle
+}
+
+// NewEncoder returns a new encoder that writes to w. By default it
+// indents by four spaces, ends lines with "\n" and writes indented groups
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, width: 4, newline: "\n"}
+}
+
+// SetIndent sets the number of spaces every level is indented by, at
+// least 1
+func (enc *Encoder) SetIndent(width int) {
+	enc.width = width
+	enc.tabs = false
+}
+
+// UseTabs indents every level by a tab instead of spaces
+func (enc *Encoder) UseTabs() {
+	enc.tabs = true
+}
+
+// SetLineEnding sets the line ending, either "\n" or "\r\n"
+func (enc *Encoder) SetLineEnding(newline string) {
+	enc.newline = newline
+}
+
+// SetGroupStyle sets the way groups are written
+func (enc *Encoder) SetGroupStyle(style GroupStyle) {
+	enc.style = style
+}
+
+// Encode writes the Pure encoding of the struct v to the stream
+func (enc *Encoder) Encode(v interface{}) error {
+	if enc.newline != "\n" && enc.newline != "\r\n" {
+		return fmt.Errorf("pure: invalid line ending %q", enc.newline)
+	}
+	if !enc.tabs && enc.width < 1 {
+		return fmt.Errorf("pure: invalid indent width %d", enc.width)
+	}
+
+	e := newEncoder()
+	e.newline = enc.newline
+	e.style = enc.style
+	e.indent = strings.Repeat(" ", enc.width)
+	if enc.tabs {
+		e.indent = "\t"
+	}
+
+	if err := e.marshal(v); err != nil {
+		return err
+	}
+	_, err := enc.w.Write(e.buf.Bytes())
+	return err
+}
+
+// nodes writes the members of a group or the items of an array
+func (e *encoder) nodes(n *Node) {
+	for _, c := range n.Children {
+		prefix := ""
+		if c.Key != "" {
+			prefix = c.Key + " = "
+		}
+
+		switch c.Kind {
+		case PropertyNode:
+			e.line(prefix + c.Raw)
+		case GroupNode:
+			e.line(c.Key)
+			e.indentlevel++
+			e.nodes(c)
+			e.indentlevel--
+		case ArrayNode, MapNode:
+			e.line(prefix + "[")
+			e.indentlevel++
+			e.nodes(c)
+			e.indentlevel--
+			e.line("]")
+		}
+	}
+}
+
+// nodeSource returns the Pure source of n. That's the literal of a
+// property, the members of a group, or an array with its brackets
+func nodeSource(n *Node) []byte {
+	if n.Kind == PropertyNode {
+		return []byte(n.Raw)
+	}
+
+	e := newEncoder()
+	if n.Kind == GroupNode {
+		e.nodes(n)
+		return e.buf.Bytes()
+	}
+
+	e.buf.WriteString("[" + e.newline)
+	e.indentlevel++
+	e.nodes(n)
+	e.indentlevel--
+	e.buf.WriteString("]")
+	return e.buf.Bytes()
+}
+
+// quoteString returns str as a quoted Pure string
+func quoteString(str string) (string, error) {
+	if strings.ContainsAny(str, "\r\n") {
+		return "", fmt.Errorf("pure: cannot write string %q with line breaks", str)
+	}
+	str = strings.Replace(str, "\\", "\\\\", -1)
+	str = strings.Replace(str, "\"", "\\\"", -1)
+	return "\"" + str + "\"", nil
+}
+
+// formatFloat writes f without an exponent, unless it's very large or
+// very small, the way encoding/json does
+func formatFloat(f float64, bits int) string {
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	return strconv.FormatFloat(f, format, -1, bits)
+}
+
+var byteUnits = []struct {
+	symbol string
+	size   int64
+}{
+	{"PiB", 1 << 50}, {"PB", 1e15},
+	{"TiB", 1 << 40}, {"TB", 1e12},
+	{"GiB", 1 << 30}, {"GB", 1e9},
+	{"MiB", 1 << 20}, {"MB", 1e6},
+	{"KiB", 1 << 10}, {"kB", 1e3},
+}
+
+// formatBytes writes n bytes in the largest unit that takes at most
+// three decimals, e.g. 512MiB or 1.5kB
+func formatBytes(n *big.Rat) string {
+	abs := new(big.Rat).Abs(n)
+	for _, u := range byteUnits {
+		size := new(big.Rat).SetInt64(u.size)
+		if abs.Cmp(size) < 0 {
+			continue
+		}
+
+		q := new(big.Rat).Quo(n, size)
+		if new(big.Rat).Mul(q, big.NewRat(1000, 1)).IsInt() {
+			return strings.TrimRight(strings.TrimRight(q.FloatString(3), "0"), ".") + u.symbol
+		}
+	}
+	return n.Num().String() + "B"
+}
+
+// formatDuration writes d without the zero minutes and seconds
+// time.Duration adds, e.g. 1h instead of 1h0m0s
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// formatValue returns v written as a Pure value. Slices are written
+// as inline arrays
+func formatValue(v reflect.Value) (string, error) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return formatFloat(v.Float(), v.Type().Bits()), nil
+	case reflect.Complex64:
+		return strconv.FormatComplex(v.Complex(), 'g', -1, 64), nil
+	case reflect.Complex128:
+		return strconv.FormatComplex(v.Complex(), 'g', -1, 128), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.String:
+		return quoteString(v.String())
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			return formatValue(v.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		items := make([]string, v.Len())
+		for i := range items {
+			item, err := formatValue(v.Index(i))
+			if err != nil {
+				return "", err
+			}
+			items[i] = item
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	}
+
+	if !v.IsValid() {
+		return "", fmt.Errorf("pure: cannot write nil as a value")
+	}
+	return "", fmt.Errorf("pure: cannot write %s as a value", v.Type())
+}
@@ -0,0 +1,147 @@
+package pure
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+type level int
+
+func (l level) MarshalPure() ([]byte, error) {
+	return []byte([]string{"debug", "info", "warn"}[l]), nil
+}
+
+func (l *level) UnmarshalPure(raw []byte) error {
+	for i, name := range []string{"debug", "info", "warn"} {
+		if string(raw) == name {
+			*l = level(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown level %q", raw)
+}
+
+type label string
+
+func (l label) MarshalText() ([]byte, error) {
+	return []byte(l), nil
+}
+
+func (l *label) UnmarshalText(text []byte) error {
+	*l = label(text)
+	return nil
+}
+
+type server struct {
+	Host string `pure:"host"`
+	Port int    `pure:"port"`
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+	}{
+		{"properties", &struct {
+			Int    int     `pure:"int"`
+			Float  float64 `pure:"float"`
+			Bool   bool    `pure:"bool"`
+			String string  `pure:"string"`
+		}{-4, 1.25, true, "say \"hi\""}},
+		{"groups", &struct {
+			Server  server  `pure:"server"`
+			Pointer *server `pure:"pointer"`
+		}{server{"a", 1}, &server{"b", 2}}},
+		{"arrays", &struct {
+			Ints    []int    `pure:"ints"`
+			Strings []string `pure:"strings"`
+		}{[]int{1, 2, 3}, []string{"a", "b, c"}}},
+		{"arrays of groups", &struct {
+			Servers  []server  `pure:"servers"`
+			Pointers []*server `pure:"pointers"`
+		}{[]server{{"a", 1}, {"b", 2}}, []*server{{"c", 3}}}},
+		{"maps", &struct {
+			Ints    map[string]int    `pure:"ints"`
+			Servers map[string]server `pure:"servers"`
+		}{map[string]int{"a": 1, "b": 2}, map[string]server{"x": {"a", 1}}}},
+		{"unquoted", &struct {
+			String  string   `pure:"string,unquoted"`
+			Strings []string `pure:"strings,unquoted"`
+		}{"hello #world", []string{"debug", "info", "example.com", "a, b", "x = 1", "#c", "a #c", "[x]", `back\slash`}}},
+		{"marshalers", &struct {
+			Level  level   `pure:"level"`
+			Levels []level `pure:"levels"`
+		}{2, []level{0, 1}}},
+		{"text marshalers", &struct {
+			Labels   []label `pure:"labels"`
+			Unquoted []label `pure:"unquoted,unquoted"`
+		}{[]label{"a b", "c"}, []label{"debug", "two.three"}}},
+		{"quantities", &struct {
+			Size  Quantity   `pure:"size"`
+			Sizes []Quantity `pure:"sizes"`
+		}{Quantity{1.5, mustUnit("GiB")}, []Quantity{{5, mustUnit("m^2")}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := Marhsal(test.v)
+			if err != nil {
+				t.Fatalf("Marhsal: %v", err)
+			}
+
+			got := reflect.New(reflect.TypeOf(test.v).Elem())
+			if err := Unmarshal(b, got.Interface()); err != nil {
+				t.Fatalf("Unmarshal: %v\n%s", err, b)
+			}
+			if !reflect.DeepEqual(got.Interface(), test.v) {
+				t.Errorf("got %+v, want %+v\n%s", got.Elem(), reflect.ValueOf(test.v).Elem(), b)
+			}
+		})
+	}
+}
+
+func TestMarshalInvalidItem(t *testing.T) {
+	v := struct {
+		Strings []string `pure:"strings,unquoted"`
+	}{[]string{"trailing "}}
+	if _, err := Marhsal(&v); err == nil {
+		t.Error("expected an error for an unquoted item with trailing blanks")
+	}
+}
+
+func mustUnit(s string) Unit {
+	u, err := ParseUnit(s)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+func TestEncoderSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(enc *Encoder)
+		ok   bool
+	}{
+		{"default", func(enc *Encoder) {}, true},
+		{"indent 2", func(enc *Encoder) { enc.SetIndent(2) }, true},
+		{"indent 0", func(enc *Encoder) { enc.SetIndent(0) }, false},
+		{"indent -1", func(enc *Encoder) { enc.SetIndent(-1) }, false},
+		{"tabs", func(enc *Encoder) { enc.SetIndent(0); enc.UseTabs() }, true},
+		{"crlf", func(enc *Encoder) { enc.SetLineEnding("\r\n") }, true},
+		{"cr", func(enc *Encoder) { enc.SetLineEnding("\r") }, false},
+	}
+
+	v := struct {
+		Server server `pure:"server"`
+	}{server{"a", 1}}
+	for _, test := range tests {
+		enc := NewEncoder(ioutil.Discard)
+		test.set(enc)
+		if err := enc.Encode(&v); (err == nil) != test.ok {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+	}
+}
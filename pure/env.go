@@ -0,0 +1,158 @@
+package pure
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// expandEnv replaces ${VAR} in s with the value of VAR, as looked up
+// by lookup. Like in a shell, ${VAR:-default} falls back to default and
+// ${VAR:?message} fails with message when VAR is unset or empty. Any
+// other '$' is kept as it is, and $${ is a literal ${
+func expandEnv(s string, lookup func(string) (string, bool)) (string, error) {
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+
+	var buf strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			buf.WriteString(s)
+			return buf.String(), nil
+		}
+
+		// $${ escapes the variable
+		if i > 0 && s[i-1] == '$' {
+			buf.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+		buf.WriteString(s[:i])
+		s = s[i+2:]
+
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable ${%s", s)
+		}
+		val, err := expandVar(s[:end], lookup)
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(val)
+		s = s[end+1:]
+	}
+}
+
+// expandVar returns the value of the variable written as ${expr}
+func expandVar(expr string, lookup func(string) (string, bool)) (string, error) {
+	name, op, arg := expr, "", ""
+	if i := strings.Index(expr, ":"); i >= 0 && i+1 < len(expr) && (expr[i+1] == '-' || expr[i+1] == '?') {
+		name, op, arg = expr[:i], expr[i:i+2], expr[i+2:]
+	}
+
+	if name == "" || strings.IndexFunc(name, func(r rune) bool { return r > 0x7f || !isEnvChar(byte(r)) }) >= 0 {
+		return "", fmt.Errorf("invalid variable name %q", name)
+	}
+
+	val, _ := lookup(name)
+	if val != "" {
+		return escapeEnv(val), nil
+	}
+
+	switch op {
+	case ":-":
+		// The default is written in the source, so it's escaped already
+		return arg, nil
+	case ":?":
+		if arg == "" {
+			arg = "not set"
+		}
+		return "", fmt.Errorf("%s: %s", name, arg)
+	}
+	return "", nil
+}
+
+func isEnvChar(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') || b == '_'
+}
+
+// escapeEnv escapes the value of a variable, so that it comes out as
+// it is when the value it's put in is unquoted
+func escapeEnv(val string) string {
+	val = strings.Replace(val, "\\", "\\\\", -1)
+	return strings.Replace(val, "\"", "\\\"", -1)
+}
+
+// overlay sets the fields of the struct v, and of the groups in it,
+// that have an environment variable named after their key. The key
+// agroup.double is read from APP_AGROUP_DOUBLE with the prefix APP.
+// It reports whether any field was set
+func (d *decodeState) overlay(v reflect.Value, key string) bool {
+	lookup := d.lookupEnv
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+
+	set := false
+	tv := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		tag, opts := parseTag(tv.Field(i).Tag.Get("pure"))
+		if tag == "" || tag == "-" || tv.Field(i).PkgPath != "" {
+			continue
+		}
+		field, fkey := v.Field(i), joinKey(key, tag)
+
+		name := envName(d.envPrefix, fkey)
+		if val, ok := lookup(name); ok {
+			d.value(envNode(name, val), field, fkey, opts)
+			set = true
+			continue
+		}
+
+		// Groups are only allocated if something in them is set
+		switch {
+		case implementsUnmarshaler(field.Type()):
+		case field.Kind() == reflect.Struct:
+			set = d.overlay(field, fkey) || set
+		case field.Kind() == reflect.Ptr && field.Type().Elem().Kind() == reflect.Struct:
+			if !field.IsNil() {
+				set = d.overlay(field.Elem(), fkey) || set
+				break
+			}
+			group := reflect.New(field.Type().Elem())
+			if d.overlay(group.Elem(), fkey) {
+				field.Set(group)
+				set = true
+			}
+		}
+	}
+	return set
+}
+
+// envName returns the environment variable that overrides key
+func envName(prefix, key string) string {
+	name := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
+
+// envNode returns the value of the environment variable name as a
+// node. Arrays are written as in Pure, anything else is taken as it is
+func envNode(name, val string) *Node {
+	pos := Position{Filename: "$" + name}
+	if strings.HasPrefix(strings.TrimSpace(val), "[") {
+		p := newTreeParser(pos.Filename, []byte("v = "+val))
+		p.parse()
+		if len(p.errs) == 0 && len(p.root.Children) == 1 {
+			n := p.root.Children[0]
+			n.Key = ""
+			return n
+		}
+	}
+	return &Node{Kind: PropertyNode, Raw: escapeEnv(val), Pos: pos}
+}
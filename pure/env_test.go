@@ -0,0 +1,170 @@
+package pure
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testEnv = map[string]string{
+	"A":     "a",
+	"EMPTY": "",
+	"QUOTE": `q"z\`,
+}
+
+func lookupTestEnv(name string) (string, bool) {
+	val, ok := testEnv[name]
+	return val, ok
+}
+
+func TestExpandEnv(t *testing.T) {
+	tests := []struct {
+		in, out string
+		err     string // Error message, empty for none
+	}{
+		{"${A}", "a", ""},
+		{"x${A}y${A}", "xaya", ""},
+		{"$A", "$A", ""},
+		{"pa$$word", "pa$$word", ""},
+		{"lit$eral", "lit$eral", ""},
+		{"cost: 5$", "cost: 5$", ""},
+		{"$${A}", "${A}", ""},
+		{"$$${A}", "$${A}", ""},
+		{"${UNSET}", "", ""},
+		{"${UNSET:-def}", "def", ""},
+		{"${EMPTY:-def}", "def", ""},
+		{"${A:-def}", "a", ""},
+		{"${UNSET:-}", "", ""},
+		{"${QUOTE}", `q\"z\\`, ""},
+		{"${UNSET:?need it}", "", "UNSET: need it"},
+		{"${EMPTY:?}", "", "EMPTY: not set"},
+		{"${A:?need it}", "a", ""},
+		{"${A", "", "unterminated variable ${A"},
+		{"${}", "", `invalid variable name ""`},
+		{"${A-B}", "", `invalid variable name "A-B"`},
+	}
+
+	for _, test := range tests {
+		out, err := expandEnv(test.in, lookupTestEnv)
+		switch {
+		case test.err != "":
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%q: got error %v, want %s", test.in, err, test.err)
+			}
+		case err != nil:
+			t.Errorf("%q: %v", test.in, err)
+		case out != test.out:
+			t.Errorf("%q: got %q, want %q", test.in, out, test.out)
+		}
+	}
+}
+
+func TestDecoderExpandEnv(t *testing.T) {
+	src := "tagged = ${A}-$A\nplain = ${A}\nquoted = \"${QUOTE}\"\nunquoted = ${QUOTE}\npassword = pa$$word\n"
+	type config struct {
+		Tagged   string `pure:"tagged,env"`
+		Plain    string `pure:"plain"`
+		Quoted   string `pure:"quoted"`
+		Unquoted string `pure:"unquoted,unquoted"`
+		Password string `pure:"password,unquoted"`
+	}
+
+	// Only tagged fields are expanded by default
+	var v config
+	dec := NewDecoder(strings.NewReader(src))
+	dec.SetEnvLookup(lookupTestEnv)
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Tagged != "a-$A" || v.Plain != "${A}" {
+		t.Errorf("got %+v", v)
+	}
+
+	v = config{}
+	dec = NewDecoder(strings.NewReader(src))
+	dec.SetEnvLookup(lookupTestEnv)
+	dec.ExpandEnv()
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	want := config{"a-$A", "a", `q"z\`, `q"z\`, "pa$$word"}
+	if v != want {
+		t.Errorf("got %+v, want %+v", v, want)
+	}
+
+	dec = NewDecoder(strings.NewReader("tagged = ${UNSET:?set UNSET}\n"))
+	dec.SetEnvLookup(lookupTestEnv)
+	var derr *DecodeError
+	if err := dec.Decode(&v); !errors.As(err, &derr) || derr.Kind != ExpandFailed || derr.Key != "tagged" {
+		t.Errorf("got %v, want an %s error for tagged", err, ExpandFailed)
+	}
+}
+
+func TestEnvOverlay(t *testing.T) {
+	type config struct {
+		Name   string  `pure:"name"`
+		Server server  `pure:"server"`
+		TLS    *server `pure:"tls"`
+		Proxy  *server `pure:"proxy"`
+		Nested struct {
+			Inner struct {
+				Port int `pure:"port"`
+			} `pure:"inner"`
+		} `pure:"nested"`
+		Ports []int `pure:"ports"`
+	}
+	src := "name = \"file\"\nserver\n    host = \"h\"\n    port = 1\nports = [1]\n"
+
+	decode := func(env map[string]string, v *config) error {
+		dec := NewDecoder(strings.NewReader(src))
+		dec.SetEnvLookup(func(name string) (string, bool) {
+			val, ok := env[name]
+			return val, ok
+		})
+		dec.SetEnvOverlay("APP")
+		return dec.Decode(v)
+	}
+
+	var v config
+	err := decode(map[string]string{
+		"APP_NAME":              `a "b" $c`,
+		"APP_SERVER_PORT":       "8080",
+		"APP_TLS_HOST":          "t",
+		"APP_NESTED_INNER_PORT": "9",
+		"APP_PORTS":             "[80, 443]",
+		"NAME":                  "unprefixed",
+	}, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != `a "b" $c` || v.Server != (server{"h", 8080}) || v.Nested.Inner.Port != 9 {
+		t.Errorf("got %+v", v)
+	}
+	if v.TLS == nil || *v.TLS != (server{"t", 0}) {
+		t.Errorf("got tls = %+v, want it allocated", v.TLS)
+	}
+	if v.Proxy != nil {
+		t.Errorf("got proxy = %+v, want nil with nothing set", v.Proxy)
+	}
+	if want := []int{80, 443}; !reflect.DeepEqual(v.Ports, want) {
+		t.Errorf("got ports = %v, want %v", v.Ports, want)
+	}
+
+	// Without variables the file is left as it is
+	v = config{}
+	if err := decode(nil, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "file" || v.Server != (server{"h", 1}) || v.TLS != nil || len(v.Ports) != 1 {
+		t.Errorf("got %+v", v)
+	}
+
+	// Errors are reported at the variable
+	v = config{}
+	var derr *DecodeError
+	err = decode(map[string]string{"APP_SERVER_PORT": "http"}, &v)
+	if !errors.As(err, &derr) || derr.Kind != ValueIncorrectType || derr.Key != "server.port" || derr.Pos.Filename != "$APP_SERVER_PORT" {
+		t.Errorf("got %v, want a %s error at $APP_SERVER_PORT", err, ValueIncorrectType)
+	}
+}
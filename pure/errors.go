@@ -0,0 +1,158 @@
+package pure
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Krognol/go-pure/pure/scanner"
+)
+
+// ErrorKind classifies the errors reported while decoding a Pure source.
+type ErrorKind int
+
+const (
+	// UnknownError is the zero value and is never reported on purpose
+	UnknownError ErrorKind = iota
+
+	// Syntax errors
+	InvalidSyntax
+	MissingValue
+	MissingIdentifier
+	UnterminatedArray
+	UnterminatedString
+	IncorrectIndent
+	IncludeFailed
+	SourceTooLarge
+	IncludeCycle
+	TooManyImportedFiles
+	IncludeOutsideRoot
+
+	// Decoding errors
+	ValueIncorrectType
+	ArrayIncorrectType
+	KeyNotFound
+	UnexpectedKey
+	KeyAlreadyDefined
+	GroupAlreadyDefined
+	ArrayMultipleTypes
+	ReferenceCycle
+	InvalidEdit
+	NumberTooLarge
+	RoundedDecimal
+	PathNotFound
+	NotADirectory
+	ExpandFailed
+)
+
+var errorKindNames = [...]string{
+	UnknownError:         "unknown error",
+	InvalidSyntax:        "invalid syntax",
+	MissingValue:         "missing value",
+	MissingIdentifier:    "missing identifier",
+	UnterminatedArray:    "unterminated array",
+	UnterminatedString:   "unterminated string",
+	IncorrectIndent:      "incorrect indentation",
+	IncludeFailed:        "include failed",
+	SourceTooLarge:       "source too large",
+	IncludeCycle:         "include cycle",
+	TooManyImportedFiles: "too many imported files",
+	IncludeOutsideRoot:   "include outside of root",
+	ValueIncorrectType:   "value has incorrect type",
+	ArrayIncorrectType:   "array has incorrect type",
+	KeyNotFound:          "key not found",
+	UnexpectedKey:        "unexpected key",
+	KeyAlreadyDefined:    "key already defined",
+	GroupAlreadyDefined:  "group already defined",
+	ArrayMultipleTypes:   "array has multiple types",
+	ReferenceCycle:       "reference cycle",
+	InvalidEdit:          "invalid edit",
+	NumberTooLarge:       "number too large",
+	RoundedDecimal:       "decimal would be rounded",
+	PathNotFound:         "path not found",
+	NotADirectory:        "not a directory",
+	ExpandFailed:         "environment variable expansion failed",
+}
+
+func (k ErrorKind) String() string {
+	if k >= 0 && int(k) < len(errorKindNames) && errorKindNames[k] != "" {
+		return errorKindNames[k]
+	}
+	return "ErrorKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Position is a location in a Pure source
+type Position = scanner.Position
+
+// SyntaxError is returned when the source isn't valid Pure
+type SyntaxError struct {
+	Pos  Position
+	Kind ErrorKind
+	Key  string // Key path being parsed, if known
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Key != "" {
+		return fmt.Sprintf("pure: %s: %s: %s", e.Pos, e.Key, e.Msg)
+	}
+	return fmt.Sprintf("pure: %s: %s", e.Pos, e.Msg)
+}
+
+// DecodeError is returned when a value can't be stored in the
+// field its key maps to, or a Document can't be edited as asked
+type DecodeError struct {
+	Pos   Position
+	Kind  ErrorKind
+	Key   string // Dotted key path, e.g. "agroup.double"
+	Value string // Raw value from the source
+	Msg   string
+	Err   error // Underlying error, if any
+}
+
+func (e *DecodeError) Error() string {
+	msg := e.Msg
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if e.Key != "" {
+		return fmt.Sprintf("pure: %s: %s: %s", e.Pos, e.Key, msg)
+	}
+	return fmt.Sprintf("pure: %s: %s", e.Pos, msg)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorList is a list of errors collected by UnmarshalAll. Syntax
+// errors come first, then decoding errors
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+
+	msgs := make([]string, len(l))
+	for i, err := range l {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap lets errors.Is and errors.As look at every error in the list
+func (l ErrorList) Unwrap() []error {
+	return l
+}
+
+// Err returns an error equivalent to the list, or nil if it's empty
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
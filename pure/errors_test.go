@@ -0,0 +1,35 @@
+package pure
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestErrorMessages(t *testing.T) {
+	pos := Position{Filename: "a.pure", Line: 2, Column: 5}
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&SyntaxError{Pos: pos, Key: "a.b", Msg: "bad"}, "pure: a.pure:2:5: a.b: bad"},
+		{&SyntaxError{Pos: pos, Msg: "bad"}, "pure: a.pure:2:5: bad"},
+		{&DecodeError{Pos: pos, Key: "a.b", Msg: "bad"}, "pure: a.pure:2:5: a.b: bad"},
+		{&DecodeError{Pos: pos, Key: "a.b", Msg: "bad", Err: errors.New("why")}, "pure: a.pure:2:5: a.b: bad: why"},
+		{&DecodeError{Msg: "bad", Err: errors.New("why")}, "pure: -: bad: why"},
+		{ErrorList{}, "no errors"},
+		{ErrorList{&SyntaxError{Pos: pos, Msg: "x"}, &DecodeError{Pos: pos, Msg: "y"}}, "pure: a.pure:2:5: x\npure: a.pure:2:5: y"},
+	}
+
+	for _, test := range tests {
+		if got := test.err.Error(); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+
+	// The root group has no key
+	var i int
+	err := Unmarshal([]byte("a = 1\n"), &i)
+	if err == nil || err.Error() != "pure: -: cannot decode group as int" {
+		t.Errorf("got %v", err)
+	}
+}
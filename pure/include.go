@@ -0,0 +1,110 @@
+package pure
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Resolver reads the files named in %include lines. Relative names are
+// joined with the directory of the including file before ReadFile gets
+// them, unless the source has no file name
+type Resolver interface {
+	ReadFile(name string) ([]byte, error)
+}
+
+// GlobResolver is a Resolver that can also list the files matching a
+// pattern, in the syntax of path.Match. It's needed to include names
+// with wildcards, like conf.d/*.pure
+type GlobResolver interface {
+	Resolver
+	Glob(pattern string) ([]string, error)
+}
+
+// StatResolver is a Resolver that can also describe files. Paths with
+// the exists or dir tag option are checked with it, and on the file
+// system with other resolvers
+type StatResolver interface {
+	Resolver
+	Stat(name string) (fs.FileInfo, error)
+}
+
+// ResolverFunc lets an ordinary function be used as a Resolver
+type ResolverFunc func(name string) ([]byte, error)
+
+func (f ResolverFunc) ReadFile(name string) ([]byte, error) {
+	return f(name)
+}
+
+// osResolver reads included files from the file system
+type osResolver struct{}
+
+func (osResolver) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+func (osResolver) Glob(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+func (osResolver) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// FSResolver returns a GlobResolver that reads included files from
+// fsys, for example an embed.FS or an fstest.MapFS
+func FSResolver(fsys fs.FS) GlobResolver {
+	return fsResolver{fsys}
+}
+
+type fsResolver struct {
+	fsys fs.FS
+}
+
+func (r fsResolver) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(r.fsys, filepath.ToSlash(name))
+}
+
+func (r fsResolver) Glob(pattern string) ([]string, error) {
+	return fs.Glob(r.fsys, filepath.ToSlash(pattern))
+}
+
+func (r fsResolver) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(r.fsys, filepath.ToSlash(name))
+}
+
+// HTTPResolver reads included files from a web server. Their names are
+// appended to BaseURL, so with http://example.com/conf/ an include of
+// db.pure is fetched from http://example.com/conf/db.pure
+type HTTPResolver struct {
+	BaseURL string
+	Client  *http.Client // http.DefaultClient if nil
+}
+
+func (r HTTPResolver) ReadFile(name string) ([]byte, error) {
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	url := strings.TrimSuffix(r.BaseURL, "/") + "/" + strings.TrimPrefix(filepath.ToSlash(name), "/")
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// A missing file is skipped by %include?
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("GET %s: %s: %w", url, resp.Status, fs.ErrNotExist)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,332 @@
+package pure
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// writeFiles creates the files in dir, with their parent directories
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, src := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestIncludeRoot(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	writeFiles(t, dir, map[string]string{
+		"secret.pure":         "a = 666\n",
+		"root/inc.pure":       "a = 1\n",
+		"root/conf.d/b.pure":  "b = 2\n",
+		"root/nested.pure":    "%include conf.d/../../secret.pure\n",
+		"root/glob/safe.pure": "b = 3\n",
+	})
+
+	links := true
+	for _, name := range []string{"root/link.pure", "root/glob/link.pure"} {
+		if err := os.Symlink(filepath.Join(dir, "secret.pure"), filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			links = false
+		}
+	}
+
+	secret := filepath.ToSlash(filepath.Join(dir, "secret.pure"))
+	tests := []struct {
+		name string
+		src  string
+		link bool
+		err  string // Start of the error message, empty for none
+	}{
+		{"inside", "%include inc.pure\n", false, ""},
+		{"subdirectory", "%include conf.d/b.pure\n", false, ""},
+		{"dot dot inside", "%include conf.d/../inc.pure\n", false, ""},
+		{"glob inside", "%include conf.d/*.pure\n", false, ""},
+		{"absolute", "%include " + secret + "\n", false, `include "` + secret + `" is an absolute path`},
+		{"parent", "%include ../secret.pure\n", false, `include "../secret.pure" is outside of`},
+		{"dot dot escape", "%include conf.d/../../secret.pure\n", false, `include "conf.d/../../secret.pure" is outside of`},
+		{"nested escape", "%include nested.pure\n", false, `include "conf.d/../../secret.pure" is outside of`},
+		{"optional escape", "%include? ../secret.pure\n", false, `include "../secret.pure" is outside of`},
+		{"symlink", "%include link.pure\n", true, `include "link.pure" links to`},
+		{"glob symlink", "%include glob/*.pure\n", true, `include "` + filepath.Join("glob", "link.pure") + `" links to`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.link && !links {
+				t.Skip("symbolic links aren't supported")
+			}
+
+			var v struct {
+				A int `pure:"a"`
+				B int `pure:"b"`
+			}
+			dec := NewDecoder(strings.NewReader(test.src))
+			dec.SetIncludeRoot(root)
+			err := dec.Decode(&v)
+
+			if test.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			var serr *SyntaxError
+			if !errors.As(err, &serr) || serr.Kind != IncludeOutsideRoot || !strings.HasPrefix(serr.Msg, test.err) {
+				t.Fatalf("got %v, want %s error %s...", err, IncludeOutsideRoot, test.err)
+			}
+			if v.A == 666 {
+				t.Error("the file outside the root was read")
+			}
+		})
+	}
+}
+
+func TestHTTPResolver(t *testing.T) {
+	files := map[string]string{
+		"/conf/a.pure":     "a = 1\n",
+		"/conf/sub/b.pure": "%include c.pure\n",
+		"/conf/sub/c.pure": "b = 2\n",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/conf/broken.pure" {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		src, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(src))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		src  string
+		a, b int
+		err  ErrorKind // UnknownError for none
+	}{
+		{"found", "%include a.pure\n", 1, 0, UnknownError},
+		{"relative to the including file", "%include sub/b.pure\n", 0, 2, UnknownError},
+		{"missing", "%include nope.pure\n", 0, 0, IncludeFailed},
+		{"optional missing", "%include? nope.pure\nb = 3\n", 0, 3, UnknownError},
+		{"server error", "%include? broken.pure\n", 0, 0, IncludeFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var v struct {
+				A int `pure:"a"`
+				B int `pure:"b"`
+			}
+			dec := NewDecoder(strings.NewReader(test.src))
+			dec.SetIncludeResolver(HTTPResolver{BaseURL: srv.URL + "/conf/"})
+			err := dec.Decode(&v)
+
+			if test.err == UnknownError {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if v.A != test.a || v.B != test.b {
+					t.Errorf("got %+v, want a = %d, b = %d", v, test.a, test.b)
+				}
+				return
+			}
+
+			var serr *SyntaxError
+			if !errors.As(err, &serr) || serr.Kind != test.err {
+				t.Errorf("got %v, want a %s error", err, test.err)
+			}
+		})
+	}
+
+	_, err := HTTPResolver{BaseURL: srv.URL}.ReadFile("nope.pure")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v, want an error wrapping fs.ErrNotExist", err)
+	}
+}
+
+func TestMountedReferences(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.pure": {Data: []byte("host = \"root\"\nport = 1\nprimary %include db.pure\nreplica %include db.pure\nreplica.host = \"replica\"\n")},
+		"db.pure":   {Data: []byte("host = \"db\"\nurl => host\nrootport => port\n")},
+	}
+
+	type db struct {
+		URL      string `pure:"url"`
+		RootPort int    `pure:"rootport"`
+	}
+	var v struct {
+		Primary db `pure:"primary"`
+		Replica db `pure:"replica"`
+	}
+	if err := UnmarshalFS(fsys, "main.pure", &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Primary != (db{"db", 1}) || v.Replica != (db{"replica", 1}) {
+		t.Errorf("got %+v", v)
+	}
+}
+
+func TestIncludeGlob(t *testing.T) {
+	// The including file is in a directory whose name has wildcards
+	files := map[string]string{
+		"conf[1]*/conf.d/2.pure":  "v = \"two\"\n",
+		"conf[1]*/conf.d/10.pure": "v = \"ten\"\n",
+		"conf[1]*/conf.d/x.txt":   "v = \"txt\"\n",
+		"conf[1]*/b.pure":         "v = \"b\"\n",
+		"conf[1]*/sub/c.pure":     "%include ../b.pure\n",
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		want string
+		err  ErrorKind // UnknownError for none
+	}{
+		{"plain", "%include b.pure\n", "b", UnknownError},
+		{"nested", "%include sub/c.pure\n", "b", UnknownError},
+		{"lexical order", "%include conf.d/*.pure\n", "two", UnknownError},
+		{"character class", "%include conf.d/[1]*.pure\n", "ten", UnknownError},
+		{"zero matches", "v = \"main\"\n%include none/*.pure\n", "main", UnknownError},
+		{"missing", "%include nope.pure\n", "", IncludeFailed},
+		{"optional missing", "v = \"main\"\n%include? nope.pure\n", "main", UnknownError},
+		{"optional found", "%include? b.pure\n", "b", UnknownError},
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, files)
+	fsys := fstest.MapFS{}
+	for name, src := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(src)}
+	}
+
+	decoders := []struct {
+		name      string
+		unmarshal func(src string, v interface{}) error
+	}{
+		{"file system", func(src string, v interface{}) error {
+			name := filepath.Join(dir, "conf[1]*", "main.pure")
+			if err := os.WriteFile(name, []byte(src), 0644); err != nil {
+				t.Fatal(err)
+			}
+			return UnmarshalFile(name, v)
+		}},
+		{"fs.FS", func(src string, v interface{}) error {
+			fsys["conf[1]*/main.pure"] = &fstest.MapFile{Data: []byte(src)}
+			return UnmarshalFS(fsys, "conf[1]*/main.pure", v)
+		}},
+	}
+
+	for _, dec := range decoders {
+		for _, test := range tests {
+			t.Run(dec.name+"/"+test.name, func(t *testing.T) {
+				var v struct {
+					V string `pure:"v"`
+				}
+				err := dec.unmarshal(test.src, &v)
+
+				if test.err == UnknownError {
+					if err != nil {
+						t.Fatal(err)
+					}
+					if v.V != test.want {
+						t.Errorf("got %q, want %q", v.V, test.want)
+					}
+					return
+				}
+
+				var serr *SyntaxError
+				if !errors.As(err, &serr) || serr.Kind != test.err {
+					t.Errorf("got %v, want a %s error", err, test.err)
+				}
+			})
+		}
+	}
+}
+
+func TestIncludeLimits(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.pure": {Data: []byte("%include main.pure\n")},
+		"self.pure": {Data: []byte("%include self.pure\n")},
+		"a.pure":    {Data: []byte("%include b.pure\n")},
+		"b.pure":    {Data: []byte("%include a.pure\n")},
+		"f1.pure":   {Data: []byte("a = 1\n")},
+		"f2.pure":   {Data: []byte("b = 2\n")},
+		"f3.pure":   {Data: []byte("c = 3\n")},
+	}
+	// d0.pure includes d1.pure, and so on, 40 deep
+	for i := 0; i < 40; i++ {
+		fsys[fmt.Sprintf("d%d.pure", i)] = &fstest.MapFile{Data: []byte(fmt.Sprintf("%%include d%d.pure\n", i+1))}
+	}
+	fsys["d40.pure"] = &fstest.MapFile{Data: []byte("a = 1\n")}
+
+	tests := []struct {
+		name         string
+		src          string
+		depth, files int
+		limits       bool
+		err          ErrorKind // UnknownError for none
+		msg          string
+	}{
+		{"self include", "%include self.pure\n", 0, 0, false, IncludeCycle, "include cycle self.pure -> self.pure"},
+		{"cycle", "%include a.pure\n", 0, 0, false, IncludeCycle, "include cycle a.pure -> b.pure -> a.pure"},
+		{"included twice", "%include f1.pure\ng %include f1.pure\n", 0, 0, false, UnknownError, ""},
+		{"default depth", "%include d0.pure\n", 0, 0, false, TooManyImportedFiles, "includes are nested more than 32 deep"},
+		{"depth", "%include d0.pure\n", 2, 0, true, TooManyImportedFiles, "includes are nested more than 2 deep"},
+		{"depth off", "%include d0.pure\n", 0, 0, true, UnknownError, ""},
+		{"depth allows", "%include d38.pure\n", 3, 0, true, UnknownError, ""},
+		{"files", "%include f*.pure\n", 0, 2, true, TooManyImportedFiles, "more than 2 files are included"},
+		{"files allows", "%include f*.pure\n", 0, 3, true, UnknownError, ""},
+		{"files counts nested", "%include d38.pure\n", 0, 2, true, TooManyImportedFiles, "more than 2 files are included"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var v map[string]interface{}
+			dec := NewDecoder(strings.NewReader(test.src))
+			dec.SetIncludeResolver(FSResolver(fsys))
+			if test.limits {
+				dec.SetIncludeLimits(test.depth, test.files)
+			}
+			err := dec.Decode(&v)
+
+			if test.err == UnknownError {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			var serr *SyntaxError
+			if !errors.As(err, &serr) || serr.Kind != test.err || serr.Msg != test.msg {
+				t.Errorf("got %v, want %s error %q", err, test.err, test.msg)
+			}
+		})
+	}
+
+	// The chain starts at the file being decoded
+	var v map[string]interface{}
+	var serr *SyntaxError
+	err := UnmarshalFS(fsys, "main.pure", &v)
+	if !errors.As(err, &serr) || serr.Kind != IncludeCycle || serr.Msg != "include cycle main.pure -> main.pure" {
+		t.Errorf("got %v, want an %s error", err, IncludeCycle)
+	}
+}
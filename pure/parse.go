@@ -0,0 +1,708 @@
+package pure
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Krognol/go-pure/pure/scanner"
+)
+
+// treeParser builds a Document from the tokens of a scanner.
+// Errors don't stop it, it records them and resumes on the next line
+type treeParser struct {
+	scan     *scanner.Scanner
+	filename string
+	src      []byte
+	pos      Position
+	tok      scanner.Token
+	lit      string
+
+	// Offset right after the last token that wasn't a newline
+	end int
+
+	// Nodes from included files don't get a span, they can't be edited
+	included bool
+
+	resolver Resolver
+	maxSize  int64 // Maximum size of included files, 0 for no limit
+
+	// Files that are being included, outermost first. Including one of
+	// them again is a cycle. level is how deep this file is included
+	includes []string
+	level    int
+
+	// Limits on how deep includes are nested and on how many files are
+	// included in total, 0 for no limit. files is shared with the
+	// parsers of included files
+	maxDepth, maxFiles int
+	files              *int
+
+	// Absolute path of the directory includes are confined to, if any
+	includeRoot string
+
+	root *Node
+	errs ErrorList
+
+	// Group an included file is parsed into, the root of its keys
+	mount *Node
+
+	// Key path of the group being parsed, used for error messages
+	keys []string
+
+	// Array nesting depth
+	depth int
+
+	// References are resolved once the whole tree is known
+	refs []*pendingRef
+}
+
+type pendingRef struct {
+	node  *Node
+	scope *Node // Group the reference was made in
+	mount *Node // Group the file it's in was included into, if any
+	key   string
+	state int // 0 unresolved, 1 resolving, 2 resolved
+}
+
+const (
+	defaultMaxIncludeDepth = 32
+	defaultMaxIncludes     = 1000
+)
+
+func newTreeParser(filename string, src []byte) *treeParser {
+	return &treeParser{
+		scan:     scanner.New(filename, src, 0),
+		filename: filename,
+		src:      src,
+		root:     &Node{Kind: GroupNode},
+		resolver: osResolver{},
+		maxDepth: defaultMaxIncludeDepth,
+		maxFiles: defaultMaxIncludes,
+		files:    new(int),
+	}
+}
+
+func (p *treeParser) next() {
+	if p.tok != scanner.NEWLINE && p.tok != scanner.EOF {
+		p.end = p.pos.Offset + len(p.lit)
+	}
+	p.pos, p.tok, p.lit = p.scan.Scan()
+}
+
+// setSpan records that n is written from pos to the end of the last
+// token read. keyOff is the offset of its last key and op the offset
+// of its '=' or '=>', both -1 if it has none
+func (p *treeParser) setSpan(n *Node, pos Position, keyOff, op, val int) {
+	if p.included {
+		return
+	}
+
+	sp := &span{
+		lineStart: pos.Offset - pos.Column + 1,
+		start:     pos.Offset,
+		end:       p.end,
+		op:        op,
+		val:       val,
+	}
+	sp.lead = string(p.src[sp.lineStart:pos.Offset])
+	if keyOff >= 0 {
+		sp.lead = string(p.src[sp.lineStart:keyOff])
+	}
+
+	// A node has its line to itself when there's only whitespace
+	// before it, and at most a ',' and a comment after it
+	sp.line = strings.TrimLeft(string(p.src[sp.lineStart:pos.Offset]), " \t") == ""
+	rest := strings.TrimLeft(string(p.src[sp.end:lineEnd(p.src, sp.end)]), " \t\r")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, ","), " \t\r")
+	if rest != "" && rest[0] != '#' {
+		sp.line = false
+	}
+	n.span = sp
+}
+
+func (p *treeParser) error(pos Position, kind ErrorKind, key, msg string) {
+	p.errs = append(p.errs, &SyntaxError{
+		Pos:  pos,
+		Kind: kind,
+		Key:  key,
+		Msg:  msg,
+	})
+}
+
+// unexpected reports the current token and skips the rest of the line
+func (p *treeParser) unexpected(key, expected string) {
+	kind := InvalidSyntax
+	msg := fmt.Sprintf("unexpected %s, expected %s", p.tok, expected)
+	switch {
+	case p.tok == scanner.ILLEGAL && strings.HasPrefix(p.lit, "\""):
+		kind, msg = UnterminatedString, "string missing closing '\"'"
+	case p.tok == scanner.ILLEGAL:
+		msg = fmt.Sprintf("unexpected %q, expected %s", p.lit, expected)
+	}
+	p.error(p.pos, kind, key, msg)
+	p.skipLine()
+}
+
+// skipLine skips ahead to the end of the current line, or the ']'
+// closing the array the line is in
+func (p *treeParser) skipLine() {
+	depth := 0
+	for p.tok != scanner.NEWLINE && p.tok != scanner.EOF {
+		switch p.tok {
+		case scanner.LBRACK:
+			depth++
+		case scanner.RBRACK:
+			if depth == 0 && p.depth > 0 {
+				return
+			}
+			depth--
+		}
+		p.next()
+	}
+}
+
+// keyPath returns the full key path of keys in the group being parsed
+func (p *treeParser) keyPath(keys ...string) string {
+	return strings.Join(append(p.keys[:len(p.keys):len(p.keys)], keys...), ".")
+}
+
+func (p *treeParser) parse() {
+	p.next()
+	p.parseBlock(p.root, "")
+	p.resolveRefs()
+}
+
+// parseBlock parses the members of group that are indented by indent.
+// It returns on the first line that's indented less
+func (p *treeParser) parseBlock(group *Node, indent string) {
+	for {
+		for p.tok == scanner.NEWLINE {
+			p.next()
+		}
+
+		if p.tok == scanner.EOF {
+			return
+		}
+
+		lineIndent := ""
+		if p.tok == scanner.INDENT {
+			lineIndent = p.lit
+		}
+
+		switch {
+		case lineIndent == indent:
+		case len(lineIndent) < len(indent) && strings.HasPrefix(indent, lineIndent):
+			// The line belongs to an outer block
+			return
+		default:
+			p.error(p.pos, IncorrectIndent, p.keyPath(), "unexpected indentation")
+			p.next()
+			p.skipLine()
+			continue
+		}
+
+		if p.tok == scanner.INDENT {
+			p.next()
+		}
+
+		switch p.tok {
+		case scanner.IDENTIFIER:
+			p.parseMember(group, lineIndent)
+		case scanner.INCLUDE:
+			p.parseInclude(group)
+		default:
+			p.unexpected(p.keyPath(), "key")
+		}
+	}
+}
+
+// parseMember parses a property, reference, group header or include
+// into a group starting at the current identifier and adds it to parent
+func (p *treeParser) parseMember(parent *Node, indent string) {
+	pos := p.pos
+	keyOff := p.pos.Offset
+	keys := []string{p.lit}
+	p.next()
+
+	for p.tok == scanner.DOT {
+		p.next()
+		if p.tok != scanner.IDENTIFIER {
+			p.error(p.pos, MissingIdentifier, p.keyPath(keys...), "missing group variable identifier")
+			p.skipLine()
+			return
+		}
+		keyOff = p.pos.Offset
+		keys = append(keys, p.lit)
+		p.next()
+	}
+	key := p.keyPath(keys...)
+
+	switch p.tok {
+	case scanner.EQUALS:
+		op := p.pos.Offset
+		p.next()
+		val := p.pos.Offset
+		p.keys = append(p.keys, keys...)
+		node := p.parseValue(key)
+		p.keys = p.keys[:len(p.keys)-len(keys)]
+		if node == nil {
+			return
+		}
+		node.Pos = pos
+		p.setSpan(node, pos, keyOff, op, val)
+		p.set(parent, keys, node)
+	case scanner.REF:
+		op := p.pos.Offset
+		p.next()
+		val := p.pos.Offset
+		ref := p.parseRefKey(key)
+		if ref == "" {
+			return
+		}
+		node := &Node{Kind: PropertyNode, Ref: ref, Pos: pos}
+		p.setSpan(node, pos, keyOff, op, val)
+		if p.set(parent, keys, node) != nil {
+			p.refs = append(p.refs, &pendingRef{node: node, scope: parent, mount: p.mount, key: key})
+		}
+	case scanner.NEWLINE, scanner.EOF:
+		group := p.set(parent, keys, &Node{Kind: GroupNode, Pos: pos})
+		if group == nil {
+			// Still parse the members so they don't cause more errors
+			group = &Node{Kind: GroupNode}
+		}
+		p.parseGroupBody(group, keys, indent)
+
+		// The span of a group header covers its indented members
+		if group.span == nil {
+			p.setSpan(group, pos, keyOff, -1, -1)
+		}
+		return
+	case scanner.INCLUDE:
+		// The top-level keys of the included files go into the group
+		group := p.set(parent, keys, &Node{Kind: GroupNode, Pos: pos})
+		if group == nil {
+			group = &Node{Kind: GroupNode}
+		}
+		p.keys = append(p.keys, keys...)
+		p.parseInclude(group)
+		p.keys = p.keys[:len(p.keys)-len(keys)]
+
+		if group.span == nil {
+			p.setSpan(group, pos, keyOff, -1, -1)
+		}
+		return
+	default:
+		p.unexpected(key, "'=', '=>', %include or a new line")
+		return
+	}
+	p.expectLineEnd(key)
+}
+
+// parseGroupBody parses the indented lines following a group header
+func (p *treeParser) parseGroupBody(group *Node, keys []string, indent string) {
+	for p.tok == scanner.NEWLINE {
+		p.next()
+	}
+
+	// A group without members is fine, the next line just isn't indented
+	if p.tok != scanner.INDENT || len(p.lit) <= len(indent) || !strings.HasPrefix(p.lit, indent) {
+		return
+	}
+
+	p.keys = append(p.keys, keys...)
+	p.parseBlock(group, p.lit)
+	p.keys = p.keys[:len(p.keys)-len(keys)]
+}
+
+// parseRefKey parses the dotted key after a '=>'
+func (p *treeParser) parseRefKey(key string) string {
+	var keys []string
+	for {
+		if p.tok != scanner.IDENTIFIER {
+			if len(keys) == 0 {
+				p.error(p.pos, MissingValue, key, "reference missing key")
+			} else {
+				p.error(p.pos, MissingIdentifier, key, "reference missing key after '.'")
+			}
+			p.skipLine()
+			return ""
+		}
+		keys = append(keys, p.lit)
+		p.next()
+
+		if p.tok != scanner.DOT {
+			return strings.Join(keys, ".")
+		}
+		p.next()
+	}
+}
+
+// parseValue parses a scalar value or an array
+func (p *treeParser) parseValue(key string) *Node {
+	switch {
+	case p.tok == scanner.LBRACK:
+		return p.parseArray(key)
+	case p.tok.IsValue():
+		n := &Node{Kind: PropertyNode, Raw: p.lit, Pos: p.pos}
+		p.next()
+		return n
+	case p.tok == scanner.NEWLINE, p.tok == scanner.EOF:
+		p.error(p.pos, MissingValue, key, "identifier missing value")
+		return nil
+	}
+	p.unexpected(key, "value")
+	return nil
+}
+
+// parseArray parses the items of an array. Arrays made of keyed
+// entries become maps
+func (p *treeParser) parseArray(key string) *Node {
+	arr := &Node{Kind: ArrayNode, Pos: p.pos}
+	var keyed, unkeyed bool
+
+	p.next()
+	p.depth++
+	defer func() { p.depth-- }()
+
+	for {
+		for p.tok == scanner.NEWLINE || p.tok == scanner.COMMA {
+			p.next()
+		}
+
+		indent := ""
+		if p.tok == scanner.INDENT {
+			indent = p.lit
+			p.next()
+		}
+
+		switch {
+		case p.tok == scanner.RBRACK:
+			p.next()
+			if keyed && unkeyed {
+				p.error(arr.Pos, ArrayMultipleTypes, key, "array mixes values and keys")
+			}
+			if keyed {
+				arr.Kind = MapNode
+			}
+			return arr
+		case p.tok == scanner.EOF:
+			p.error(arr.Pos, UnterminatedArray, key, "invalid array, missing ']'")
+			return arr
+		case p.tok == scanner.IDENTIFIER:
+			keyed = true
+			p.parseMember(arr, indent)
+		default:
+			pos := p.pos
+			item := p.parseValue(key)
+			if item == nil {
+				p.skipLine()
+				continue
+			}
+			p.setSpan(item, pos, -1, -1, pos.Offset)
+			unkeyed = true
+			arr.Children = append(arr.Children, item)
+			p.expectLineEnd(key)
+		}
+	}
+}
+
+// expectLineEnd checks that nothing follows a value on its line
+func (p *treeParser) expectLineEnd(key string) {
+	switch p.tok {
+	case scanner.NEWLINE, scanner.EOF:
+		return
+	case scanner.COMMA, scanner.RBRACK:
+		if p.depth > 0 {
+			return
+		}
+	}
+	p.unexpected(key, "a new line")
+}
+
+// set adds node to parent at the dotted key path keys, creating the
+// groups in between. A group header reuses an existing group, other
+// nodes replace what was there before. It returns the node in the tree,
+// or nil if the key conflicts with an existing one
+func (p *treeParser) set(parent *Node, keys []string, node *Node) *Node {
+	for i, key := range keys[:len(keys)-1] {
+		child := parent.Child(key)
+		switch {
+		case child == nil:
+			child = &Node{Kind: GroupNode, Key: key, Pos: node.Pos}
+			parent.Children = append(parent.Children, child)
+		case child.Kind != GroupNode:
+			p.error(node.Pos, KeyAlreadyDefined, p.keyPath(keys[:i+1]...), "key is already defined as a "+child.Kind.String())
+			return nil
+		}
+		parent = child
+	}
+
+	key := keys[len(keys)-1]
+	node.Key = key
+
+	// Groups named in arrays are items of their own, even with the
+	// same name, since arrays of groups keep every one of them
+	if parent.Kind == ArrayNode && len(keys) == 1 && node.Kind == GroupNode {
+		parent.Children = append(parent.Children, node)
+		return node
+	}
+
+	for i, child := range parent.Children {
+		if child.Key != key {
+			continue
+		}
+
+		switch {
+		case node.Kind == GroupNode && child.Kind == GroupNode:
+			return child
+		case node.Kind == GroupNode:
+			p.error(node.Pos, KeyAlreadyDefined, p.keyPath(keys...), "key is already defined as a "+child.Kind.String())
+			return nil
+		case child.Kind == GroupNode:
+			p.error(node.Pos, GroupAlreadyDefined, p.keyPath(keys...), "key is already defined as a group")
+			return nil
+		}
+		parent.Children[i] = node
+		return node
+	}
+	parent.Children = append(parent.Children, node)
+	return node
+}
+
+// parseInclude parses the included files into group. Names with
+// wildcards include every matching file in lexical order, and
+// %include? skips files that don't exist
+func (p *treeParser) parseInclude(group *Node) {
+	pos := p.pos
+	optional := p.lit == "%include?"
+	p.next()
+
+	if !p.tok.IsValue() {
+		p.error(p.pos, MissingValue, "", "no include specified")
+		p.skipLine()
+		return
+	}
+	written := unquote(p.lit)
+	p.next()
+	p.expectLineEnd("")
+
+	// Includes are relative to the file they're in, or to the root
+	// they're confined to
+	dir := ""
+	switch {
+	case filepath.IsAbs(written) && p.includeRoot != "":
+		p.error(pos, IncludeOutsideRoot, "", fmt.Sprintf("include %q is an absolute path", written))
+		return
+	case filepath.IsAbs(written):
+	case p.filename != "":
+		dir = filepath.Dir(p.filename)
+	case p.includeRoot != "":
+		dir = p.includeRoot
+	}
+	name := filepath.Join(dir, written)
+
+	// Only the name as written can have wildcards, not the directory
+	// it's joined with
+	if !strings.ContainsAny(written, "*?[") {
+		p.include(pos, group, name, written, optional)
+		return
+	}
+
+	g, ok := p.resolver.(GlobResolver)
+	if !ok {
+		p.error(pos, IncludeFailed, "", fmt.Sprintf("cannot include %q, the resolver can't list files", name))
+		return
+	}
+	matches, err := g.Glob(filepath.Join(globEscape(dir), written))
+	if err != nil {
+		p.error(pos, IncludeFailed, "", fmt.Sprintf("cannot include %q: %v", name, err))
+		return
+	}
+	sort.Strings(matches)
+	for _, match := range matches {
+		// Matches are named the way they would be written
+		written := match
+		if rel, err := filepath.Rel(dir, match); dir != "" && err == nil {
+			written = rel
+		}
+		p.include(pos, group, match, written, optional)
+	}
+}
+
+// include parses the file name into group. pos is where it's included,
+// and written the name as it's written there
+func (p *treeParser) include(pos Position, group *Node, name, written string, optional bool) {
+	name = filepath.Clean(name)
+	if p.includeRoot != "" {
+		if err := p.checkRoot(name, written); err != "" {
+			p.error(pos, IncludeOutsideRoot, "", err)
+			return
+		}
+	}
+
+	chain := p.includes
+	if p.filename != "" && !p.included {
+		chain = []string{filepath.Clean(p.filename)}
+	}
+	for i, f := range chain {
+		if f == name {
+			cycle := append(append([]string{}, chain[i:]...), name)
+			p.error(pos, IncludeCycle, "", "include cycle "+strings.Join(cycle, " -> "))
+			return
+		}
+	}
+
+	switch {
+	case p.maxDepth > 0 && p.level >= p.maxDepth:
+		p.error(pos, TooManyImportedFiles, "", fmt.Sprintf("includes are nested more than %d deep", p.maxDepth))
+		return
+	case p.maxFiles > 0 && *p.files >= p.maxFiles:
+		p.error(pos, TooManyImportedFiles, "", fmt.Sprintf("more than %d files are included", p.maxFiles))
+		return
+	}
+
+	src, err := p.resolver.ReadFile(name)
+	if optional && errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		p.error(pos, IncludeFailed, "", fmt.Sprintf("couldn't open file %q: %v", name, err))
+		return
+	}
+	*p.files++
+
+	if p.maxSize > 0 && int64(len(src)) > p.maxSize {
+		p.error(pos, SourceTooLarge, "", fmt.Sprintf("included file %q is larger than %d bytes", name, p.maxSize))
+		return
+	}
+
+	inc := newTreeParser(name, src)
+	inc.included = true
+	inc.resolver = p.resolver
+	inc.maxSize = p.maxSize
+	inc.includes = append(chain[:len(chain):len(chain)], name)
+	inc.level = p.level + 1
+	inc.maxDepth, inc.maxFiles, inc.files = p.maxDepth, p.maxFiles, p.files
+	inc.includeRoot = p.includeRoot
+	inc.root = p.root
+	inc.mount = group
+	inc.keys = p.keys
+	inc.next()
+	inc.parseBlock(group, "")
+	p.errs = append(p.errs, inc.errs...)
+	p.refs = append(p.refs, inc.refs...)
+}
+
+// globEscape escapes the wildcards in name, so a pattern starting with
+// it matches name itself
+func globEscape(name string) string {
+	var b strings.Builder
+	for _, c := range name {
+		switch {
+		case c == '*', c == '?', c == '[':
+			b.WriteString("[" + string(c) + "]")
+		case c == '\\' && filepath.Separator != '\\':
+			b.WriteString(`\\`)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// checkRoot returns why the file name, written as written, can't be
+// included if it's outside the include root. Symbolic links are
+// followed on the file system, where the file is read from by default
+func (p *treeParser) checkRoot(name, written string) string {
+	inside := func(root, name string) bool {
+		rel, err := filepath.Rel(root, name)
+		return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+	}
+
+	abs, err := filepath.Abs(name)
+	if err != nil || !inside(p.includeRoot, abs) {
+		return fmt.Sprintf("include %q is outside of %s", written, p.includeRoot)
+	}
+
+	if _, ok := p.resolver.(osResolver); !ok {
+		return ""
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		// Missing files are reported when they're read
+		return ""
+	}
+	root, err := filepath.EvalSymlinks(p.includeRoot)
+	if err != nil || !inside(root, real) {
+		return fmt.Sprintf("include %q links to %s, outside of %s", written, real, p.includeRoot)
+	}
+	return ""
+}
+
+// resolveRefs copies the referenced values into the reference nodes
+func (p *treeParser) resolveRefs() {
+	pending := make(map[*Node]*pendingRef, len(p.refs))
+	for _, ref := range p.refs {
+		pending[ref.node] = ref
+	}
+
+	for _, ref := range p.refs {
+		p.resolve(ref, pending)
+	}
+}
+
+func (p *treeParser) resolve(ref *pendingRef, pending map[*Node]*pendingRef) bool {
+	switch ref.state {
+	case 1:
+		p.error(ref.node.Pos, ReferenceCycle, ref.key, fmt.Sprintf("reference %q leads back to itself", ref.node.Ref))
+		return false
+	case 2:
+		return true
+	}
+	ref.state = 1
+
+	// Keys are looked up from the root first, then from the group the
+	// reference was made in. In included files the group they were
+	// included into comes first, it's their root
+	var target *Node
+	if ref.mount != nil {
+		target = ref.mount.Get(ref.node.Ref)
+	}
+	if target == nil {
+		target = p.root.Get(ref.node.Ref)
+	}
+	if target == nil {
+		target = ref.scope.Get(ref.node.Ref)
+	}
+	if target == nil {
+		p.error(ref.node.Pos, KeyNotFound, ref.key, fmt.Sprintf("reference %q not found", ref.node.Ref))
+		ref.state = 2
+		return false
+	}
+
+	// Whatever is referenced has to be resolved before it's copied
+	ok := true
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if r, found := pending[n]; found && !p.resolve(r, pending) {
+			ok = false
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(target)
+
+	if ok {
+		c := target.copy()
+		ref.node.Kind = c.Kind
+		ref.node.Raw = c.Raw
+		ref.node.Children = c.Children
+	}
+	ref.state = 2
+	return ok
+}
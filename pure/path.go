@@ -0,0 +1,46 @@
+package pure
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// Path is a file system path. Relative paths are resolved against the
+// directory of the file they're written in, included files too
+type Path string
+
+var pathType = reflect.TypeOf(Path(""))
+
+// path stores the path n in the string field v. The exists and dir tag
+// options check that it's there, and that it's a directory, where
+// included files are read from
+func (d *decodeState) path(n *Node, v reflect.Value, key string, opts tagOptions) {
+	if v.Kind() != reflect.String {
+		d.typeErr(n, key, v.Type(), nil)
+		return
+	}
+
+	path := filepath.FromSlash(unquote(n.Raw))
+	if !filepath.IsAbs(path) && n.Pos.Filename != "" {
+		path = filepath.Join(filepath.Dir(n.Pos.Filename), path)
+	}
+
+	if opts.Contains("exists") || opts.Contains("dir") {
+		stat := os.Stat
+		if r, ok := d.resolver.(StatResolver); ok {
+			stat = r.Stat
+		}
+		info, err := stat(path)
+		if err != nil {
+			d.error(n, PathNotFound, key, "path doesn't exist", err)
+			return
+		}
+		if opts.Contains("dir") && !info.IsDir() {
+			d.error(n, NotADirectory, key, fmt.Sprintf("path %q isn't a directory", path), nil)
+			return
+		}
+	}
+	v.SetString(path)
+}
@@ -3,17 +3,45 @@ package pure
 import (
 	"os"
 	"path/filepath"
-	"regexp"
+	"strings"
 )
 
+// QuantityValue returns the number of the quantity quant as it's
+// written, like 5.0 for 5.0m^2. Like in older versions, a number
+// that isn't at the start is found too, but digits after one of the
+// unit symbols @#%/^. belong to the unit: m^2 has no number.
+//
+// Deprecated: Use ParseQuantity, or a Quantity field.
 func QuantityValue(quant string) string {
-	reg := regexp.MustCompile(`(\d+(\.\d+)?)`)
-	return reg.FindString(quant)
+	if n := numberLen(quant); n > 0 {
+		return quant[:n]
+	}
+
+	for i := 0; i < len(quant); i++ {
+		if quant[i] < '0' || quant[i] > '9' {
+			continue
+		}
+		if i > 0 && strings.IndexByte("@#%/^.", quant[i-1]) >= 0 {
+			for i+1 < len(quant) && quant[i+1] >= '0' && quant[i+1] <= '9' {
+				i++
+			}
+			continue
+		}
+		return quant[i : i+numberLen(quant[i:])]
+	}
+	return ""
 }
 
+// QuantityUnit returns the unit of the quantity quant, like m^2 for
+// 5m^2, or "" if quant isn't a quantity.
+//
+// Deprecated: Use ParseQuantity, or a Quantity field.
 func QuantityUnit(quant string) string {
-	reg := regexp.MustCompile("([a-zA-Z_-]+[@#%/^.0-9]*)+")
-	return reg.FindString(quant)
+	q, err := ParseQuantity(quant)
+	if err != nil {
+		return ""
+	}
+	return q.Unit.String()
 }
 
 func PathDirectory(path string) string {
@@ -0,0 +1,425 @@
+package pure
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Quantity is a number with a unit, like 5m^2 or 1.5GiB
+type Quantity struct {
+	Value float64
+	Unit  Unit
+}
+
+// ParseQuantity parses a number directly followed by a unit
+func ParseQuantity(s string) (Quantity, error) {
+	n := numberLen(s)
+	if n == 0 {
+		return Quantity{}, fmt.Errorf("pure: invalid quantity %q", s)
+	}
+
+	val, err := strconv.ParseFloat(s[:n], 64)
+	if err != nil {
+		return Quantity{}, err
+	}
+
+	unit, err := ParseUnit(s[n:])
+	if err != nil {
+		return Quantity{}, err
+	}
+	return Quantity{Value: val, Unit: unit}, nil
+}
+
+// numberLen returns the length of the decimal number s starts with
+func numberLen(s string) int {
+	digits := func(i int) int {
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		return i
+	}
+
+	i := 0
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		i++
+	}
+	end := digits(i)
+	if end == i {
+		return 0
+	}
+
+	if end+1 < len(s) && s[end] == '.' {
+		if j := digits(end + 1); j > end+1 {
+			end = j
+		}
+	}
+
+	// An 'e' without digits after it is a unit
+	if end < len(s) && (s[end] == 'e' || s[end] == 'E') {
+		i := end + 1
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		if j := digits(i); j > i {
+			end = j
+		}
+	}
+	return end
+}
+
+// In converts q to unit, which has to measure the same thing as the
+// unit of q
+func (q Quantity) In(unit string) (Quantity, error) {
+	to, err := ParseUnit(unit)
+	if err != nil {
+		return Quantity{}, err
+	}
+
+	factor, err := conversion(q.Unit, to)
+	if err != nil {
+		return Quantity{}, err
+	}
+
+	// Scales are exact, so 5m^2 is exactly 50000cm^2
+	val, ok := new(big.Rat).SetString(strconv.FormatFloat(q.Value, 'g', -1, 64))
+	if !ok {
+		return Quantity{}, fmt.Errorf("pure: cannot convert %v", q.Value)
+	}
+	f, _ := val.Mul(val, factor).Float64()
+	return Quantity{Value: f, Unit: to}, nil
+}
+
+// exactIn returns the quantity s converted to unit without rounding it
+func exactIn(s string, unit Unit) (*big.Rat, error) {
+	n := numberLen(s)
+	val, ok := new(big.Rat).SetString(s[:n])
+	if n == 0 || !ok {
+		return nil, fmt.Errorf("pure: invalid quantity %q", s)
+	}
+
+	// Plain numbers are in unit already
+	from, err := ParseUnit(s[n:])
+	if err != nil || len(from.factors) == 0 {
+		return val, err
+	}
+
+	factor, err := conversion(from, unit)
+	if err != nil {
+		return nil, err
+	}
+	return val.Mul(val, factor), nil
+}
+
+// conversion returns what numbers in from are multiplied by to get
+// them in to
+func conversion(from, to Unit) (*big.Rat, error) {
+	fromScale, fromDims, err := from.base()
+	if err != nil {
+		return nil, err
+	}
+	toScale, toDims, err := to.base()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fromDims) != len(toDims) {
+		return nil, fmt.Errorf("pure: cannot convert %s to %s", from, to)
+	}
+	for dim, exp := range fromDims {
+		if toDims[dim] != exp {
+			return nil, fmt.Errorf("pure: cannot convert %s to %s", from, to)
+		}
+	}
+	return new(big.Rat).Quo(fromScale, toScale), nil
+}
+
+// String returns q the way it's written in Pure, e.g. 1.5GiB
+func (q Quantity) String() string {
+	return formatFloat(q.Value, 64) + q.Unit.String()
+}
+
+// MarshalPure writes q in its canonical form
+func (q Quantity) MarshalPure() ([]byte, error) {
+	if math.IsNaN(q.Value) || math.IsInf(q.Value, 0) {
+		return nil, fmt.Errorf("pure: cannot encode quantity %v", q.Value)
+	}
+	return []byte(q.String()), nil
+}
+
+// UnmarshalPure parses a quantity like 5m^2. The unit doesn't have to
+// be in the unit table, that's only needed to convert it
+func (q *Quantity) UnmarshalPure(raw []byte) error {
+	parsed, err := ParseQuantity(unquote(string(raw)))
+	if err != nil {
+		return err
+	}
+	*q = parsed
+	return nil
+}
+
+// Unit is a product of unit symbols raised to a power, like m^2, GiB
+// or kg.m/s^2. The zero Unit is the unit of plain numbers
+type Unit struct {
+	factors []unitFactor
+}
+
+type unitFactor struct {
+	symbol string
+	exp    int
+}
+
+// ParseUnit parses a unit. Symbols are multiplied with '.' or '*', and
+// divided with '/'. Each one can be raised to a power with '^'
+func ParseUnit(s string) (Unit, error) {
+	var u Unit
+	invalid := fmt.Errorf("pure: invalid unit %q", s)
+
+	rest := s
+	sign := 1
+	for rest != "" {
+		n := 0
+		for n < len(rest) {
+			r, size := utf8.DecodeRuneInString(rest[n:])
+			if !unicode.IsLetter(r) && r != '%' && r != '_' {
+				break
+			}
+			n += size
+		}
+		if n == 0 {
+			return Unit{}, invalid
+		}
+
+		f := unitFactor{symbol: rest[:n], exp: sign}
+		rest = rest[n:]
+
+		if strings.HasPrefix(rest, "^") {
+			n = 1
+			if n < len(rest) && rest[n] == '-' {
+				n++
+			}
+			for n < len(rest) && rest[n] >= '0' && rest[n] <= '9' {
+				n++
+			}
+			exp, err := strconv.Atoi(rest[1:n])
+			if err != nil || exp == 0 {
+				return Unit{}, invalid
+			}
+			f.exp *= exp
+			rest = rest[n:]
+		}
+		u.mul(f)
+
+		if rest == "" {
+			break
+		}
+		switch rest[0] {
+		case '.', '*':
+			sign = 1
+		case '/':
+			sign = -1
+		default:
+			return Unit{}, invalid
+		}
+		rest = rest[1:]
+		if rest == "" {
+			return Unit{}, invalid
+		}
+	}
+	return u, nil
+}
+
+// mul multiplies u by f, so m.m becomes m^2 and m/m nothing
+func (u *Unit) mul(f unitFactor) {
+	for i := range u.factors {
+		if u.factors[i].symbol != f.symbol {
+			continue
+		}
+		u.factors[i].exp += f.exp
+		if u.factors[i].exp == 0 {
+			u.factors = append(u.factors[:i], u.factors[i+1:]...)
+		}
+		return
+	}
+	u.factors = append(u.factors, f)
+}
+
+// String returns the canonical form of u. Symbols with a positive power
+// come first, in the order they were written, followed by the ones
+// that are divided by
+func (u Unit) String() string {
+	var b strings.Builder
+	for _, f := range u.factors {
+		if f.exp > 0 {
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			writeFactor(&b, f.symbol, f.exp)
+		}
+	}
+
+	// Without anything to divide, powers are negative
+	divide := b.Len() > 0
+	for _, f := range u.factors {
+		switch {
+		case f.exp > 0:
+			continue
+		case divide:
+			b.WriteByte('/')
+			writeFactor(&b, f.symbol, -f.exp)
+		default:
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			writeFactor(&b, f.symbol, f.exp)
+		}
+	}
+	return b.String()
+}
+
+func writeFactor(b *strings.Builder, symbol string, exp int) {
+	b.WriteString(symbol)
+	if exp != 1 {
+		b.WriteByte('^')
+		b.WriteString(strconv.Itoa(exp))
+	}
+}
+
+// base returns the scale of u in base units, and the powers of the base
+// units it's made of
+func (u Unit) base() (*big.Rat, map[string]int, error) {
+	units.RLock()
+	defer units.RUnlock()
+
+	scale := big.NewRat(1, 1)
+	dims := make(map[string]int)
+	for _, f := range u.factors {
+		def, ok := units.m[f.symbol]
+		if !ok {
+			return nil, nil, fmt.Errorf("pure: unknown unit %q", f.symbol)
+		}
+
+		for i := 0; i < f.exp; i++ {
+			scale.Mul(scale, def.scale)
+		}
+		for i := 0; i > f.exp; i-- {
+			scale.Quo(scale, def.scale)
+		}
+
+		for dim, exp := range def.dims {
+			dims[dim] += exp * f.exp
+			if dims[dim] == 0 {
+				delete(dims, dim)
+			}
+		}
+	}
+	return scale, dims, nil
+}
+
+// unitDef is an entry of the unit table
+type unitDef struct {
+	scale *big.Rat       // In base units
+	dims  map[string]int // Powers of the base units
+}
+
+// units is the unit table quantities are converted with
+var units = struct {
+	sync.RWMutex
+	m map[string]unitDef
+}{m: make(map[string]unitDef)}
+
+// RegisterUnit adds symbol to the unit table used by Quantity.In. The
+// unit is scale times def, which is a unit made of symbols that are
+// already in the table, e.g. RegisterUnit("ft", 0.3048, "m"). An empty
+// def makes symbol a new base unit
+func RegisterUnit(symbol string, scale float64, def string) error {
+	if u, err := ParseUnit(symbol); err != nil || len(u.factors) != 1 || u.factors[0].symbol != symbol {
+		return fmt.Errorf("pure: invalid unit symbol %q", symbol)
+	}
+	if scale <= 0 || math.IsInf(scale, 0) || math.IsNaN(scale) {
+		return fmt.Errorf("pure: invalid scale %v for unit %q", scale, symbol)
+	}
+
+	defScale, dims := big.NewRat(1, 1), map[string]int{symbol: 1}
+	if def != "" {
+		u, err := ParseUnit(def)
+		if err != nil {
+			return err
+		}
+		if defScale, dims, err = u.base(); err != nil {
+			return err
+		}
+	}
+
+	// The shortest decimal of scale is what was meant, 0.01 is 1/100
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(scale, 'g', -1, 64))
+	r.Mul(r, defScale)
+
+	units.Lock()
+	defer units.Unlock()
+	if _, ok := units.m[symbol]; ok {
+		return fmt.Errorf("pure: unit %q is already registered", symbol)
+	}
+	units.m[symbol] = unitDef{scale: r, dims: dims}
+	return nil
+}
+
+func init() {
+	for _, u := range []struct {
+		symbol string
+		scale  float64
+		def    string
+	}{
+		{"m", 1, ""},
+		{"km", 1000, "m"},
+		{"cm", 0.01, "m"},
+		{"mm", 0.001, "m"},
+		{"um", 1e-6, "m"},
+		{"µm", 1e-6, "m"},
+		{"nm", 1e-9, "m"},
+		{"L", 0.001, "m^3"},
+		{"mL", 0.001, "L"},
+
+		{"g", 1, ""},
+		{"kg", 1000, "g"},
+		{"mg", 0.001, "g"},
+		{"t", 1e6, "g"},
+
+		{"s", 1, ""},
+		{"ns", 1e-9, "s"},
+		{"us", 1e-6, "s"},
+		{"µs", 1e-6, "s"},
+		{"ms", 0.001, "s"},
+		{"min", 60, "s"},
+		{"h", 3600, "s"},
+		{"d", 86400, "s"},
+		{"Hz", 1, "s^-1"},
+		{"kHz", 1e3, "Hz"},
+		{"MHz", 1e6, "Hz"},
+		{"GHz", 1e9, "Hz"},
+
+		{"B", 1, ""},
+		{"kB", 1e3, "B"},
+		{"MB", 1e6, "B"},
+		{"GB", 1e9, "B"},
+		{"TB", 1e12, "B"},
+		{"PB", 1e15, "B"},
+		{"KiB", 1 << 10, "B"},
+		{"MiB", 1 << 20, "B"},
+		{"GiB", 1 << 30, "B"},
+		{"TiB", 1 << 40, "B"},
+		{"PiB", 1 << 50, "B"},
+	} {
+		if err := RegisterUnit(u.symbol, u.scale, u.def); err != nil {
+			panic(err)
+		}
+	}
+
+	// Percentages are plain numbers
+	units.m["%"] = unitDef{scale: big.NewRat(1, 100), dims: map[string]int{}}
+}
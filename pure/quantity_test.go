@@ -0,0 +1,30 @@
+package pure
+
+import "testing"
+
+func TestQuantityValueUnit(t *testing.T) {
+	tests := []struct {
+		quant, value, unit string
+	}{
+		{"5m^2", "5", "m^2"},
+		{"1.5GiB", "1.5", "GiB"},
+		{"9.81m/s^2", "9.81", "m/s^2"},
+		{"-3kg.m", "-3", "kg.m"},
+		{"42", "42", ""},
+		{"m^2", "", ""},
+		{"5.0m^2", "5.0", "m^2"},
+		{"1e3m", "1e3", "m"},
+		{"+2s", "+2", "s"},
+		{"x5m", "5", ""},
+		{"m^23x7.5", "7.5", ""},
+	}
+
+	for _, test := range tests {
+		if got := QuantityValue(test.quant); got != test.value {
+			t.Errorf("QuantityValue(%q) = %q, want %q", test.quant, got, test.value)
+		}
+		if got := QuantityUnit(test.quant); got != test.unit {
+			t.Errorf("QuantityUnit(%q) = %q, want %q", test.quant, got, test.unit)
+		}
+	}
+}
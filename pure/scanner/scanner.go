@@ -0,0 +1,566 @@
+// Package scanner splits Pure sources into tokens.
+//
+// Every token comes with its position and its literal, which is always
+// the exact source text of the token. It's meant for syntax
+// highlighters, linters and other tools that need to look at a Pure
+// source without decoding it.
+package scanner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Token is the type of a lexical token
+type Token int
+
+const (
+	ILLEGAL Token = iota
+	EOF
+	NEWLINE
+	INDENT // Leading whitespace of a line with content
+	COMMENT
+	IDENTIFIER
+	EQUALS  // =
+	REF     // =>
+	DOT     // .
+	LBRACK  // [
+	RBRACK  // ]
+	COMMA   // ,
+	INCLUDE // %include or %include?
+
+	// Values
+	STRING   // "quoted"
+	UNQUOTED // unquoted string
+	INT
+	DOUBLE
+	BOOL
+	QUANTITY
+	PATH
+	ENV
+)
+
+var tokens = [...]string{
+	ILLEGAL:    "illegal",
+	EOF:        "end of file",
+	NEWLINE:    "newline",
+	INDENT:     "indentation",
+	COMMENT:    "comment",
+	IDENTIFIER: "identifier",
+	EQUALS:     "'='",
+	REF:        "'=>'",
+	DOT:        "'.'",
+	LBRACK:     "'['",
+	RBRACK:     "']'",
+	COMMA:      "','",
+	INCLUDE:    "%include",
+	STRING:     "string",
+	UNQUOTED:   "unquoted string",
+	INT:        "int",
+	DOUBLE:     "double",
+	BOOL:       "bool",
+	QUANTITY:   "quantity",
+	PATH:       "path",
+	ENV:        "environment variable",
+}
+
+func (t Token) String() string {
+	if t >= 0 && int(t) < len(tokens) {
+		return tokens[t]
+	}
+	return fmt.Sprintf("Token(%d)", int(t))
+}
+
+// IsValue reports whether t is a scalar value
+func (t Token) IsValue() bool {
+	return t >= STRING && int(t) < len(tokens)
+}
+
+// Position is a location in a Pure source
+type Position struct {
+	Filename string // Name of the source file, empty when scanning raw bytes
+	Offset   int    // Byte offset, starting at 0
+	Line     int    // Line number, starting at 1
+	Column   int    // Column number in bytes, starting at 1
+}
+
+// IsValid reports whether the position has been set
+func (pos Position) IsValid() bool {
+	return pos.Line > 0
+}
+
+func (pos Position) String() string {
+	s := pos.Filename
+	if pos.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
+
+// Mode controls which optional tokens the Scanner returns
+type Mode uint
+
+const (
+	ScanComments Mode = 1 << iota // Return COMMENT tokens instead of skipping them
+)
+
+type lexMode int
+
+const (
+	// Keys, '=', '=>', '.' and comments
+	lexKey lexMode = iota
+
+	// The value after a '=' or an include
+	lexValue
+
+	// The start of an array item, which can be a value or a key
+	lexItem
+)
+
+// Scanner splits a Pure source into tokens. Values are context
+// sensitive, so it keeps track of whether it's expecting a key,
+// a value or an array item
+type Scanner struct {
+	src      []byte
+	filename string
+	mode     Mode
+
+	off     int // Offset of the next unread byte
+	line    int // Current line, starting at 1
+	lineOff int // Offset of the start of the current line
+
+	state  lexMode
+	depth  int  // Array nesting depth
+	indent bool // Whether the indentation of the current line was handled
+}
+
+// New returns a Scanner for src. The filename is only used in positions
+func New(filename string, src []byte, mode Mode) *Scanner {
+	return &Scanner{
+		src:      src,
+		filename: filename,
+		mode:     mode,
+		line:     1,
+	}
+}
+
+func (s *Scanner) pos(off int) Position {
+	return Position{
+		Filename: s.filename,
+		Offset:   off,
+		Line:     s.line,
+		Column:   off - s.lineOff + 1,
+	}
+}
+
+func (s *Scanner) peekByte(off int) byte {
+	if off < len(s.src) {
+		return s.src[off]
+	}
+	return 0
+}
+
+// skipBlank returns the offset of the first byte after the spaces
+// and tabs starting at off
+func (s *Scanner) skipBlank(off int) int {
+	for off < len(s.src) && (s.src[off] == ' ' || s.src[off] == '\t' || (s.src[off] == '\r' && s.peekByte(off+1) != '\n')) {
+		off++
+	}
+	return off
+}
+
+// isNewline reports whether a newline starts at off
+func (s *Scanner) isNewline(off int) bool {
+	return s.peekByte(off) == '\n' || (s.peekByte(off) == '\r' && s.peekByte(off+1) == '\n')
+}
+
+func (s *Scanner) emit(start int, tok Token) (Position, Token, string) {
+	return s.pos(start), tok, string(s.src[start:s.off])
+}
+
+// Scan returns the next token with its position and literal text.
+// At the end of the source it keeps returning EOF
+func (s *Scanner) Scan() (pos Position, tok Token, lit string) {
+	for {
+		pos, tok, lit = s.scan()
+		if tok != COMMENT || s.mode&ScanComments != 0 {
+			return
+		}
+	}
+}
+
+func (s *Scanner) scan() (Position, Token, string) {
+	// Indentation is only significant on lines with content
+	if !s.indent {
+		s.indent = true
+		end := s.skipBlank(s.off)
+		if end > s.off {
+			if b := s.peekByte(end); end < len(s.src) && !s.isNewline(end) && b != '#' {
+				start := s.off
+				s.off = end
+				return s.emit(start, INDENT)
+			}
+		}
+	}
+
+	s.off = s.skipBlank(s.off)
+	start := s.off
+
+	if s.off >= len(s.src) {
+		return s.pos(start), EOF, ""
+	}
+
+	if s.isNewline(s.off) {
+		if s.src[s.off] == '\r' {
+			s.off++
+		}
+		s.off++
+		pos, tok, lit := s.emit(start, NEWLINE)
+		s.line++
+		s.lineOff = s.off
+		s.indent = false
+		s.state = lexKey
+		if s.depth > 0 {
+			s.state = lexItem
+		}
+		return pos, tok, lit
+	}
+
+	c := s.src[s.off]
+
+	switch s.state {
+	case lexValue:
+		return s.scanValue()
+	case lexItem:
+		switch {
+		case c == '#':
+			return s.scanComment()
+		case c == ']', c == ',':
+			// Handled as in key mode below
+		case isIdentStart(c) && s.itemIsKey():
+			return s.scanIdent()
+		default:
+			return s.scanValue()
+		}
+	}
+
+	switch {
+	case c == '#':
+		return s.scanComment()
+	case isIdentStart(c):
+		return s.scanIdent()
+	}
+
+	s.off++
+	switch c {
+	case '.':
+		return s.emit(start, DOT)
+	case '=':
+		if s.peekByte(s.off) == '>' {
+			s.off++
+			s.state = lexKey
+			return s.emit(start, REF)
+		}
+		s.state = lexValue
+		return s.emit(start, EQUALS)
+	case '%':
+		for s.off < len(s.src) && isAlNum(s.src[s.off]) {
+			s.off++
+		}
+		if string(s.src[start:s.off]) == "%include" {
+			// %include? is the form that skips missing files
+			if s.peekByte(s.off) == '?' {
+				s.off++
+			}
+			s.state = lexValue
+			return s.emit(start, INCLUDE)
+		}
+		return s.emit(start, ILLEGAL)
+	case ']':
+		if s.depth > 0 {
+			s.depth--
+			s.state = lexKey
+			return s.emit(start, RBRACK)
+		}
+	case ',':
+		if s.depth > 0 {
+			s.state = lexItem
+			return s.emit(start, COMMA)
+		}
+	}
+	return s.emit(start, ILLEGAL)
+}
+
+// itemIsKey reports whether the array item starting at the current
+// offset is a key, either followed by '=', '=>' or '.', or alone on
+// its line as the header of a group. A word alone on its line is only
+// a group header if the next line is indented more, otherwise it's an
+// unquoted value
+func (s *Scanner) itemIsKey() bool {
+	end := s.off
+	for end < len(s.src) && isIdentChar(s.src[end]) {
+		end++
+	}
+	word := string(s.src[s.off:end])
+	end = s.skipBlank(end)
+
+	switch b := s.peekByte(end); {
+	case b == '=', b == '.':
+		return true
+	case end >= len(s.src), s.isNewline(end):
+		return classify(word) == UNQUOTED && s.indentedNext(end)
+	}
+	return false
+}
+
+// indentedNext reports whether the first line with content after the
+// line break at off is indented more than the current line
+func (s *Scanner) indentedNext(off int) bool {
+	indent := s.skipBlank(s.lineOff) - s.lineOff
+	for off < len(s.src) {
+		// Skip to the start of the next line
+		for off < len(s.src) && s.src[off] != '\n' {
+			off++
+		}
+		if off >= len(s.src) {
+			return false
+		}
+		off++
+
+		start := off
+		off = s.skipBlank(off)
+		if off >= len(s.src) || s.isNewline(off) || s.src[off] == '#' {
+			continue
+		}
+		return off-start > indent
+	}
+	return false
+}
+
+func (s *Scanner) scanComment() (Position, Token, string) {
+	start := s.off
+	for s.off < len(s.src) && !s.isNewline(s.off) {
+		s.off++
+	}
+	return s.emit(start, COMMENT)
+}
+
+func (s *Scanner) scanIdent() (Position, Token, string) {
+	start := s.off
+	for s.off < len(s.src) && isIdentChar(s.src[s.off]) {
+		s.off++
+	}
+	s.state = lexKey
+	return s.emit(start, IDENTIFIER)
+}
+
+func (s *Scanner) scanValue() (Position, Token, string) {
+	start := s.off
+	startPos := s.pos(start)
+	switch s.src[s.off] {
+	case '[':
+		s.off++
+		s.depth++
+		s.state = lexItem
+		return s.emit(start, LBRACK)
+	case ']':
+		if s.depth > 0 {
+			s.off++
+			s.depth--
+			s.state = lexKey
+			return s.emit(start, RBRACK)
+		}
+	case '"':
+		return s.scanString()
+	}
+
+	// Anything up to the end of the line is part of the value, apart
+	// from trailing whitespace and a comment, which starts with a '#'
+	// after whitespace. Inside arrays ',' and ']' end it too
+	end := s.off
+	for s.off < len(s.src) && !s.isNewline(s.off) {
+		c := s.src[s.off]
+		if s.depth > 0 && (c == ',' || c == ']') {
+			break
+		}
+		if c == '#' && s.off > start && (s.src[s.off-1] == ' ' || s.src[s.off-1] == '\t') {
+			break
+		}
+
+		if c == '\\' {
+			if next := s.continuation(s.off + 1); next > 0 {
+				s.off = next
+				end = next
+				continue
+			}
+
+			// Skip the escaped byte
+			if s.off+1 < len(s.src) && !s.isNewline(s.off+1) {
+				s.off++
+			}
+		}
+
+		s.off++
+		if c != ' ' && c != '\t' && c != '\r' {
+			end = s.off
+		}
+	}
+	s.off = end
+	s.state = lexKey
+
+	lit := string(s.src[start:s.off])
+	return startPos, classify(lit), lit
+}
+
+// continuation checks whether the '\' before off ends the line.
+// It returns the offset of the first non blank byte on the next line,
+// or 0 if it doesn't
+func (s *Scanner) continuation(off int) int {
+	off = s.skipBlank(off)
+	if !s.isNewline(off) {
+		return 0
+	}
+	if s.src[off] == '\r' {
+		off++
+	}
+	off++
+	s.line++
+	s.lineOff = off
+	return s.skipBlank(off)
+}
+
+func (s *Scanner) scanString() (Position, Token, string) {
+	start := s.off
+	startPos := s.pos(start)
+	s.off++
+	for {
+		if s.off >= len(s.src) || s.isNewline(s.off) {
+			s.state = lexKey
+			return startPos, ILLEGAL, string(s.src[start:s.off])
+		}
+
+		c := s.src[s.off]
+		s.off++
+
+		if c == '"' {
+			s.state = lexKey
+			return startPos, STRING, string(s.src[start:s.off])
+		}
+
+		if c == '\\' {
+			if next := s.continuation(s.off); next > 0 {
+				s.off = next
+				continue
+			}
+			if s.off < len(s.src) {
+				s.off++
+			}
+		}
+	}
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+func isAlpha(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func isAlNum(b byte) bool {
+	return isAlpha(b) || isDigit(b) || b == '_'
+}
+
+func isIdentStart(b byte) bool {
+	return isAlpha(b) || b == '_'
+}
+
+func isIdentChar(b byte) bool {
+	return isAlNum(b) || b == '-'
+}
+
+// classify returns the type of an unquoted value
+func classify(lit string) Token {
+	if lit == "" {
+		return UNQUOTED
+	}
+
+	switch {
+	case lit[0] == '$':
+		return ENV
+	case isPath(lit):
+		return PATH
+	case strings.EqualFold(lit, "true"), strings.EqualFold(lit, "false"):
+		return BOOL
+	}
+
+	n, double := numberPrefix(lit)
+	switch {
+	case n == 0:
+		return UNQUOTED
+	case n == len(lit) && double:
+		return DOUBLE
+	case n == len(lit):
+		return INT
+	case isAlpha(lit[n]) || lit[n] == '%':
+		return QUANTITY
+	}
+	return UNQUOTED
+}
+
+// numberPrefix returns the length of the number lit starts with, and
+// whether it has a fraction or exponent
+func numberPrefix(lit string) (n int, double bool) {
+	i := 0
+	if i < len(lit) && (lit[i] == '+' || lit[i] == '-') {
+		i++
+	}
+
+	digits := i
+	for i < len(lit) && isDigit(lit[i]) {
+		i++
+	}
+	if i == digits {
+		return 0, false
+	}
+
+	if i+1 < len(lit) && lit[i] == '.' && isDigit(lit[i+1]) {
+		double = true
+		i += 2
+		for i < len(lit) && isDigit(lit[i]) {
+			i++
+		}
+	}
+
+	if i < len(lit) && (lit[i] == 'e' || lit[i] == 'E') {
+		j := i + 1
+		if j < len(lit) && (lit[j] == '+' || lit[j] == '-') {
+			j++
+		}
+		if j < len(lit) && isDigit(lit[j]) {
+			double = true
+			i = j
+			for i < len(lit) && isDigit(lit[i]) {
+				i++
+			}
+		}
+	}
+	return i, double
+}
+
+func isPath(lit string) bool {
+	for _, prefix := range []string{"./", "../", ".\\", "..\\", "/", "~/", "~\\"} {
+		if strings.HasPrefix(lit, prefix) {
+			return true
+		}
+	}
+
+	// Windows volume, C:\ or C:/
+	return len(lit) > 2 && isAlpha(lit[0]) && lit[1] == ':' && (lit[2] == '\\' || lit[2] == '/')
+}
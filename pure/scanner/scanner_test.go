@@ -0,0 +1,215 @@
+package scanner
+
+import "testing"
+
+type token struct {
+	pos string
+	tok Token
+	lit string
+}
+
+func scanAll(src string, mode Mode) []token {
+	var toks []token
+	s := New("", []byte(src), mode)
+	for {
+		pos, tok, lit := s.Scan()
+		toks = append(toks, token{pos.String(), tok, lit})
+		if tok == EOF || len(toks) > 1000 {
+			return toks
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []token
+	}{
+		{"trailing comment", "a = 5 # five\n", []token{
+			{"1:1", IDENTIFIER, "a"},
+			{"1:3", EQUALS, "="},
+			{"1:5", INT, "5"},
+			{"1:7", COMMENT, "# five"},
+			{"1:13", NEWLINE, "\n"},
+			{"2:1", EOF, ""},
+		}},
+		{"comments after values", "color = #fff\ns = \"x\" # c\narr = [1, 2] # c\n", []token{
+			{"1:1", IDENTIFIER, "color"},
+			{"1:7", EQUALS, "="},
+			{"1:9", UNQUOTED, "#fff"},
+			{"1:13", NEWLINE, "\n"},
+			{"2:1", IDENTIFIER, "s"},
+			{"2:3", EQUALS, "="},
+			{"2:5", STRING, "\"x\""},
+			{"2:9", COMMENT, "# c"},
+			{"2:12", NEWLINE, "\n"},
+			{"3:1", IDENTIFIER, "arr"},
+			{"3:5", EQUALS, "="},
+			{"3:7", LBRACK, "["},
+			{"3:8", INT, "1"},
+			{"3:9", COMMA, ","},
+			{"3:11", INT, "2"},
+			{"3:12", RBRACK, "]"},
+			{"3:14", COMMENT, "# c"},
+			{"3:17", NEWLINE, "\n"},
+			{"4:1", EOF, ""},
+		}},
+		{"CRLF", "a = 1\r\nb\r\n    c = x y\r\n", []token{
+			{"1:1", IDENTIFIER, "a"},
+			{"1:3", EQUALS, "="},
+			{"1:5", INT, "1"},
+			{"1:6", NEWLINE, "\r\n"},
+			{"2:1", IDENTIFIER, "b"},
+			{"2:2", NEWLINE, "\r\n"},
+			{"3:1", INDENT, "    "},
+			{"3:5", IDENTIFIER, "c"},
+			{"3:7", EQUALS, "="},
+			{"3:9", UNQUOTED, "x y"},
+			{"3:12", NEWLINE, "\r\n"},
+			{"4:1", EOF, ""},
+		}},
+		{"continuation line", "a = one \\\n    two\nb = 2\n", []token{
+			{"1:1", IDENTIFIER, "a"},
+			{"1:3", EQUALS, "="},
+			{"1:5", UNQUOTED, "one \\\n    two"},
+			{"2:8", NEWLINE, "\n"},
+			{"3:1", IDENTIFIER, "b"},
+			{"3:3", EQUALS, "="},
+			{"3:5", INT, "2"},
+			{"3:6", NEWLINE, "\n"},
+			{"4:1", EOF, ""},
+		}},
+		{"array items", "a = [\n    hello\n    group\n        x = 1\n    k = v\n    d.e = 1\n]\n", []token{
+			{"1:1", IDENTIFIER, "a"},
+			{"1:3", EQUALS, "="},
+			{"1:5", LBRACK, "["},
+			{"1:6", NEWLINE, "\n"},
+			{"2:1", INDENT, "    "},
+			{"2:5", UNQUOTED, "hello"},
+			{"2:10", NEWLINE, "\n"},
+			{"3:1", INDENT, "    "},
+			{"3:5", IDENTIFIER, "group"},
+			{"3:10", NEWLINE, "\n"},
+			{"4:1", INDENT, "        "},
+			{"4:9", IDENTIFIER, "x"},
+			{"4:11", EQUALS, "="},
+			{"4:13", INT, "1"},
+			{"4:14", NEWLINE, "\n"},
+			{"5:1", INDENT, "    "},
+			{"5:5", IDENTIFIER, "k"},
+			{"5:7", EQUALS, "="},
+			{"5:9", UNQUOTED, "v"},
+			{"5:10", NEWLINE, "\n"},
+			{"6:1", INDENT, "    "},
+			{"6:5", IDENTIFIER, "d"},
+			{"6:6", DOT, "."},
+			{"6:7", IDENTIFIER, "e"},
+			{"6:9", EQUALS, "="},
+			{"6:11", INT, "1"},
+			{"6:12", NEWLINE, "\n"},
+			{"7:1", RBRACK, "]"},
+			{"7:2", NEWLINE, "\n"},
+			{"8:1", EOF, ""},
+		}},
+		{"inline arrays, references and includes", "a = [1, b, \"c\", 5m]\nr => a.b\n%include? x.pure\n", []token{
+			{"1:1", IDENTIFIER, "a"},
+			{"1:3", EQUALS, "="},
+			{"1:5", LBRACK, "["},
+			{"1:6", INT, "1"},
+			{"1:7", COMMA, ","},
+			{"1:9", UNQUOTED, "b"},
+			{"1:10", COMMA, ","},
+			{"1:12", STRING, "\"c\""},
+			{"1:15", COMMA, ","},
+			{"1:17", QUANTITY, "5m"},
+			{"1:19", RBRACK, "]"},
+			{"1:20", NEWLINE, "\n"},
+			{"2:1", IDENTIFIER, "r"},
+			{"2:3", REF, "=>"},
+			{"2:6", IDENTIFIER, "a"},
+			{"2:7", DOT, "."},
+			{"2:8", IDENTIFIER, "b"},
+			{"2:9", NEWLINE, "\n"},
+			{"3:1", INCLUDE, "%include?"},
+			{"3:11", UNQUOTED, "x.pure"},
+			{"3:17", NEWLINE, "\n"},
+			{"4:1", EOF, ""},
+		}},
+		{"value types", "t = true\nd = 1.5\np = ./x\ne = $HOME\nu = a\\#b # c\n", []token{
+			{"1:1", IDENTIFIER, "t"},
+			{"1:3", EQUALS, "="},
+			{"1:5", BOOL, "true"},
+			{"1:9", NEWLINE, "\n"},
+			{"2:1", IDENTIFIER, "d"},
+			{"2:3", EQUALS, "="},
+			{"2:5", DOUBLE, "1.5"},
+			{"2:8", NEWLINE, "\n"},
+			{"3:1", IDENTIFIER, "p"},
+			{"3:3", EQUALS, "="},
+			{"3:5", PATH, "./x"},
+			{"3:8", NEWLINE, "\n"},
+			{"4:1", IDENTIFIER, "e"},
+			{"4:3", EQUALS, "="},
+			{"4:5", ENV, "$HOME"},
+			{"4:10", NEWLINE, "\n"},
+			{"5:1", IDENTIFIER, "u"},
+			{"5:3", EQUALS, "="},
+			{"5:5", UNQUOTED, "a\\#b"},
+			{"5:10", COMMENT, "# c"},
+			{"5:13", NEWLINE, "\n"},
+			{"6:1", EOF, ""},
+		}},
+	}
+
+	for _, test := range tests {
+		got := scanAll(test.src, ScanComments)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %d tokens, want %d: %v", test.name, len(got), len(test.want), got)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: token %d is %s %s %q, want %s %s %q", test.name, i,
+					got[i].pos, got[i].tok, got[i].lit, test.want[i].pos, test.want[i].tok, test.want[i].lit)
+			}
+		}
+	}
+}
+
+func TestScanSkipsComments(t *testing.T) {
+	for _, tok := range scanAll("# top\na = 5 # five\nb = [\n    # item\n    1\n]\n", 0) {
+		if tok.tok == COMMENT {
+			t.Errorf("got a comment at %s without ScanComments", tok.pos)
+		}
+	}
+}
+
+func TestItemIsKey(t *testing.T) {
+	tests := []struct {
+		item string
+		key  bool
+	}{
+		{"    hello\n]\n", false},
+		{"    hello\n    world\n]\n", false},
+		{"    hello, world]\n", false},
+		{"    hello]\n", false},
+		{"    hello\n", false},
+		{"    group\n        x = 1\n]\n", true},
+		{"    group\n\n        # comment\n        x = 1\n]\n", true},
+		{"    group\r\n        x = 1\r\n]\r\n", true},
+		{"    k = v\n]\n", true},
+		{"    k => v\n]\n", true},
+		{"    k.l = v\n]\n", true},
+		{"    true\n        x = 1\n]\n", false},
+		{"    5m\n]\n", false},
+	}
+
+	for _, test := range tests {
+		toks := scanAll("a = [\n"+test.item, 0)
+		// a, '=', '[', newline, indentation, then the item
+		if got := toks[5].tok == IDENTIFIER; got != test.key {
+			t.Errorf("%q: got %s %q, want a key: %v", test.item, toks[5].tok, toks[5].lit, test.key)
+		}
+	}
+}
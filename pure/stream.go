@@ -0,0 +1,103 @@
+package pure
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// A Decoder reads and decodes a Pure source from an input stream
+type Decoder struct {
+	r    io.Reader
+	opts decodeOptions
+	done bool
+}
+
+// NewDecoder returns a new decoder that reads from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// DisallowUnknownKeys makes Decode return an error for keys that
+// don't map to a field of the destination struct
+func (dec *Decoder) DisallowUnknownKeys() {
+	dec.opts.disallowUnknownKeys = true
+}
+
+// SetIncludeResolver sets the Resolver that reads included files.
+// By default they're read from the file system
+func (dec *Decoder) SetIncludeResolver(r Resolver) {
+	dec.opts.resolver = r
+}
+
+// SetMaxSize limits the size of the source and of every included
+// file to n bytes. Reading stops as soon as the input is larger
+func (dec *Decoder) SetMaxSize(n int64) {
+	dec.opts.maxSize = n
+}
+
+// SetIncludeLimits limits how deep includes can be nested, and how
+// many files can be included in total. Going over them is a
+// TooManyImportedFiles error. A limit of 0 turns it off, by default
+// includes can be nested 32 deep and 1000 files can be included
+func (dec *Decoder) SetIncludeLimits(depth, files int) {
+	dec.opts.includeLimits = true
+	dec.opts.maxDepth, dec.opts.maxFiles = depth, files
+}
+
+// SetIncludeRoot confines includes to the directory root, for sources
+// that can't be trusted. Includes are relative to root, and absolute
+// names, names that lead out of root with "..", and symbolic links to
+// files outside of it are IncludeOutsideRoot errors
+func (dec *Decoder) SetIncludeRoot(root string) {
+	dec.opts.includeRoot = root
+}
+
+// ExpandEnv makes Decode expand environment variables in every value,
+// as it does for fields with the env tag option. See SetEnvLookup
+func (dec *Decoder) ExpandEnv() {
+	dec.opts.expandEnv = true
+}
+
+// SetEnvLookup sets the function environment variables are looked up
+// with, os.LookupEnv by default. ${VAR} is replaced by the value of
+// VAR, ${VAR:-default} falls back to default when VAR is unset or
+// empty, and ${VAR:?message} reports message as an error
+func (dec *Decoder) SetEnvLookup(lookup func(key string) (string, bool)) {
+	dec.opts.lookupEnv = lookup
+}
+
+// SetEnvOverlay makes Decode override the keys of the source with
+// environment variables named after them, prefix first. With the prefix
+// APP, APP_AGROUP_DOUBLE=2.5 sets agroup.double to 2.5. Values are
+// decoded as if they were written in the source, arrays in brackets.
+// Variables are looked up with the function set by SetEnvLookup
+func (dec *Decoder) SetEnvOverlay(prefix string) {
+	dec.opts.envOverlay = true
+	dec.opts.envPrefix = prefix
+}
+
+// Decode reads the input until EOF and stores it in the value pointed
+// to by v. A Pure source has no end marker, so the whole stream is a
+// single source and any further call returns io.EOF. Nothing is parsed
+// before EOF, so on a connection Decode blocks until the peer closes it
+func (dec *Decoder) Decode(v interface{}) error {
+	if dec.done {
+		return io.EOF
+	}
+	dec.done = true
+
+	r := dec.r
+	if dec.opts.maxSize > 0 {
+		r = io.LimitReader(r, dec.opts.maxSize+1)
+	}
+
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if errs := unmarshal("", src, v, &dec.opts); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package pure
+
+import "strings"
+
+// tagOptions is the part of a pure struct tag after the key
+type tagOptions string
+
+// parseTag splits a pure struct tag into its key and options
+func parseTag(tag string) (string, tagOptions) {
+	if i := strings.IndexByte(tag, ','); i >= 0 {
+		return tag[:i], tagOptions(tag[i+1:])
+	}
+	return tag, ""
+}
+
+// Contains reports whether the options contain opt
+func (o tagOptions) Contains(opt string) bool {
+	s := string(o)
+	for s != "" {
+		var next string
+		if i := strings.IndexByte(s, ','); i >= 0 {
+			s, next = s[:i], s[i+1:]
+		}
+		if s == opt {
+			return true
+		}
+		s = next
+	}
+	return false
+}
+
+// unquoted reports whether a string is written without quotes
+func (o tagOptions) unquoted() bool {
+	return o.Contains("unquoted") || o.Contains("quantity") || o.Contains("path") || o.Contains("env")
+}
+
+// layout returns the time layout of the layout= option. It has to be
+// the last option, so that the layout can have commas in it
+func (o tagOptions) layout() string {
+	s := string(o)
+	for {
+		if strings.HasPrefix(s, "layout=") {
+			return s[len("layout="):]
+		}
+		i := strings.IndexByte(s, ',')
+		if i < 0 {
+			return ""
+		}
+		s = s[i+1:]
+	}
+}
@@ -0,0 +1,60 @@
+package pure
+
+import (
+	"reflect"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Timestamps are RFC 3339 with a 'T' or a space between date and time.
+// Without an offset they're local dates and times
+var (
+	timeLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02 15:04:05Z07:00",
+	}
+	localLayouts = []string{
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+	}
+)
+
+// parseTime parses a timestamp or a local date, or s in layout if
+// it's set
+func parseTime(s, layout string) (time.Time, error) {
+	if layout != "" {
+		return time.ParseInLocation(layout, s, time.Local)
+	}
+
+	t, err := time.Parse(timeLayouts[0], s)
+	if err == nil {
+		return t, nil
+	}
+	for _, l := range timeLayouts[1:] {
+		if t, lerr := time.Parse(l, s); lerr == nil {
+			return t, nil
+		}
+	}
+	for _, l := range localLayouts {
+		if t, lerr := time.ParseInLocation(l, s, time.Local); lerr == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// formatTime writes t in layout, quoted, or as RFC 3339. Local dates
+// are written without the time
+func formatTime(t time.Time, layout string) (string, error) {
+	if layout != "" {
+		return quoteString(t.Format(layout))
+	}
+
+	y, m, d := t.Date()
+	if t.Location() == time.Local && t.Equal(time.Date(y, m, d, 0, 0, 0, 0, time.Local)) {
+		return t.Format("2006-01-02"), nil
+	}
+	return t.Format(time.RFC3339Nano), nil
+}
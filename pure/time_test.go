@@ -0,0 +1,119 @@
+package pure
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	offset := time.FixedZone("", 2*60*60)
+	tests := []struct {
+		s, layout string
+		want      time.Time
+		ok        bool
+	}{
+		{"2024-03-01T10:20:30Z", "", time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC), true},
+		{"2024-03-01 10:20:30Z", "", time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC), true},
+		{"2024-03-01T10:20:30.5+02:00", "", time.Date(2024, 3, 1, 10, 20, 30, 5e8, offset), true},
+		{"2024-03-01 10:20:30+02:00", "", time.Date(2024, 3, 1, 10, 20, 30, 0, offset), true},
+		{"2024-03-01T10:20:30", "", time.Date(2024, 3, 1, 10, 20, 30, 0, time.Local), true},
+		{"2024-03-01 10:20:30", "", time.Date(2024, 3, 1, 10, 20, 30, 0, time.Local), true},
+		{"2024-03-01", "", time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local), true},
+		{"Mar 1, 2024", "Jan 2, 2006", time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local), true},
+		{"2024-03-01", "Jan 2, 2006", time.Time{}, false},
+		{"2024-13-01", "", time.Time{}, false},
+		{"yesterday", "", time.Time{}, false},
+	}
+
+	for _, test := range tests {
+		got, err := parseTime(test.s, test.layout)
+		switch {
+		case (err == nil) != test.ok:
+			t.Errorf("%q: got error %v", test.s, err)
+		case test.ok && (!got.Equal(test.want) || got.Location().String() != test.want.Location().String()):
+			t.Errorf("%q: got %v, want %v", test.s, got, test.want)
+		}
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	tests := []struct {
+		t      time.Time
+		layout string
+		want   string
+	}{
+		{time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC), "", "2024-03-01T10:20:30Z"},
+		{time.Date(2024, 3, 1, 10, 20, 30, 5e8, time.FixedZone("", -90*60)), "", "2024-03-01T10:20:30.5-01:30"},
+		{time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), "", "2024-03-01T00:00:00Z"},
+		{time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local), "", "2024-03-01"},
+		{time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local), "Jan 2, 2006", `"Mar 1, 2024"`},
+	}
+
+	for _, test := range tests {
+		got, err := formatTime(test.t, test.layout)
+		if err != nil || got != test.want {
+			t.Errorf("%v: got %q, %v, want %q", test.t, got, err, test.want)
+		}
+	}
+}
+
+func TestTimeRoundTrip(t *testing.T) {
+	type times struct {
+		UTC       time.Time       `pure:"utc"`
+		Offset    time.Time       `pure:"offset"`
+		Local     time.Time       `pure:"local"`
+		LocalTime time.Time       `pure:"localtime"`
+		Layout    time.Time       `pure:"layout,layout=Mon, Jan 2, 2006 at 15:04"`
+		Pointer   *time.Time      `pure:"pointer"`
+		Timeout   time.Duration   `pure:"timeout"`
+		Backoff   []time.Duration `pure:"backoff"`
+	}
+	ptr := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := times{
+		UTC:       time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC),
+		Offset:    time.Date(2024, 3, 1, 10, 20, 30, 123, time.FixedZone("", 5*60*60)),
+		Local:     time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local),
+		LocalTime: time.Date(2024, 3, 1, 10, 20, 0, 0, time.Local),
+		Layout:    time.Date(2024, 3, 1, 10, 20, 0, 0, time.Local),
+		Pointer:   &ptr,
+		Timeout:   90 * time.Second,
+		Backoff:   []time.Duration{time.Second, 2500 * time.Millisecond, time.Hour},
+	}
+
+	b, err := Marhsal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got times
+	if err := Unmarshal(b, &got); err != nil {
+		t.Fatalf("%v\n%s", err, b)
+	}
+
+	for _, f := range []struct {
+		name      string
+		got, want time.Time
+	}{
+		{"utc", got.UTC, v.UTC},
+		{"offset", got.Offset, v.Offset},
+		{"local", got.Local, v.Local},
+		{"localtime", got.LocalTime, v.LocalTime},
+		{"layout", got.Layout, v.Layout},
+	} {
+		if !f.got.Equal(f.want) {
+			t.Errorf("%s: got %v, want %v\n%s", f.name, f.got, f.want, b)
+		}
+	}
+	if _, off := got.Offset.Zone(); off != 5*60*60 {
+		t.Errorf("offset: got %v, want +05:00", got.Offset)
+	}
+	if got.Local.Location() != time.Local {
+		t.Errorf("local: got %v, want a local date", got.Local)
+	}
+	if got.Pointer == nil || !got.Pointer.Equal(ptr) {
+		t.Errorf("pointer: got %v, want %v", got.Pointer, ptr)
+	}
+	if got.Timeout != v.Timeout || !reflect.DeepEqual(got.Backoff, v.Backoff) {
+		t.Errorf("got %v and %v, want %v and %v\n%s", got.Timeout, got.Backoff, v.Timeout, v.Backoff, b)
+	}
+}